@@ -0,0 +1,201 @@
+package plausible
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Packages too large to send to Lambda inline are uploaded to the app's
+// artifact bucket and deployed from there. The bucket is the provider's
+// artifact_bucket, or one created for the app, and is versioned so that each
+// deployment names the exact object it used. Each function keeps its
+// artifacts under its own prefix, and only the most recent
+// artifact_retention of them are kept. Layers are always published inline, so
+// their packages are limited to directUploadLimit.
+
+// directUploadLimit is the largest package Lambda accepts inline
+const directUploadLimit = 50 * 1024 * 1024
+
+// artifactBucketName returns the provider's artifact bucket, or the name of
+// the one created for the app
+func artifactBucketName(d *schema.ResourceData, m interface{}) string {
+	if bucket := m.(*AWSClient).ArtifactBucket; bucket != "" {
+		return bucket
+	}
+	return strings.ToLower(fmt.Sprintf("%s-artifacts-%s", m.(*AWSClient).AppName, d.Get("account_id").(string)))
+}
+
+// ensureArtifactBucket creates the app's artifact bucket unless it exists
+func ensureArtifactBucket(m interface{}, bucket string) error {
+	conn := m.(*AWSClient).s3conn
+
+	_, err := conn.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String(bucket)})
+	if err == nil {
+		return nil
+	}
+	if !isAWSErrRequestFailureStatusCode(err, 404) && !isAWSErr(err, s3.ErrCodeNoSuchBucket, "") {
+		return fmt.Errorf("Error reading artifact bucket %s: %s", bucket, err)
+	}
+	if m.(*AWSClient).ArtifactBucket != "" {
+		return fmt.Errorf("artifact bucket %s does not exist", bucket)
+	}
+
+	input := &s3.CreateBucketInput{Bucket: aws.String(bucket)}
+	if region := aws.StringValue(conn.Config.Region); region != "us-east-1" {
+		input.CreateBucketConfiguration = &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String(region),
+		}
+	}
+	if _, err := conn.CreateBucket(input); err != nil && !isAWSErr(err, s3.ErrCodeBucketAlreadyOwnedByYou, "") {
+		return fmt.Errorf("Error creating artifact bucket %s: %s", bucket, err)
+	}
+	_, err = conn.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket: aws.String(bucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{
+			Status: aws.String(s3.BucketVersioningStatusEnabled),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error enabling versioning of artifact bucket %s: %s", bucket, err)
+	}
+	_, err = conn.PutPublicAccessBlock(&s3.PutPublicAccessBlockInput{
+		Bucket: aws.String(bucket),
+		PublicAccessBlockConfiguration: &s3.PublicAccessBlockConfiguration{
+			BlockPublicAcls:       aws.Bool(true),
+			BlockPublicPolicy:     aws.Bool(true),
+			IgnorePublicAcls:      aws.Bool(true),
+			RestrictPublicBuckets: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error blocking public access to artifact bucket %s: %s", bucket, err)
+	}
+	return nil
+}
+
+// artifactLocation is where an uploaded package was put
+type artifactLocation struct {
+	Bucket  string
+	Key     string
+	Version string
+}
+
+// uploadArtifact uploads a package under prefix, named after its content
+func uploadArtifact(d *schema.ResourceData, m interface{}, prefix string, file []byte) (*artifactLocation, error) {
+	bucket := artifactBucketName(d, m)
+	if err := ensureArtifactBucket(m, bucket); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(file)
+	key := fmt.Sprintf("%s/%s.zip", prefix, hex.EncodeToString(sum[:]))
+	out, err := m.(*AWSClient).s3conn.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(file),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error uploading artifact %s to %s: %s", key, bucket, err)
+	}
+	return &artifactLocation{Bucket: bucket, Key: key, Version: aws.StringValue(out.VersionId)}, nil
+}
+
+// functionCode sends small packages inline and uploads large ones
+func functionCode(d *schema.ResourceData, m interface{}, file []byte) (*lambda.FunctionCode, error) {
+	if len(file) <= directUploadLimit {
+		return &lambda.FunctionCode{ZipFile: file}, nil
+	}
+	location, err := uploadArtifact(d, m, d.Get("function_name").(string), file)
+	if err != nil {
+		return nil, err
+	}
+	return &lambda.FunctionCode{
+		S3Bucket:        aws.String(location.Bucket),
+		S3Key:           aws.String(location.Key),
+		S3ObjectVersion: aws.String(location.Version),
+	}, nil
+}
+
+// artifactVersion is one version of an artifact, or a delete marker
+type artifactVersion struct {
+	key, version string
+	modified     time.Time
+}
+
+// expiredArtifacts returns the versions to delete so that only the most
+// recent keep remain. Versions modified at the same time are ordered by key
+// and version, so the same listing always expires the same versions.
+func expiredArtifacts(versions []artifactVersion, keep int) []artifactVersion {
+	if len(versions) <= keep {
+		return nil
+	}
+	sorted := append([]artifactVersion{}, versions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].modified.Equal(sorted[j].modified) {
+			return sorted[i].modified.After(sorted[j].modified)
+		}
+		if sorted[i].key != sorted[j].key {
+			return sorted[i].key > sorted[j].key
+		}
+		return sorted[i].version > sorted[j].version
+	})
+	return sorted[keep:]
+}
+
+// deleteArtifacts deletes all but the most recent keep artifacts under
+// prefix. The bucket is left alone if it was never created.
+func deleteArtifacts(d *schema.ResourceData, m interface{}, prefix string, keep int) error {
+	conn := m.(*AWSClient).s3conn
+	bucket := artifactBucketName(d, m)
+
+	versions := []artifactVersion{}
+	err := conn.ListObjectVersionsPages(&s3.ListObjectVersionsInput{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix + "/"),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, v := range page.Versions {
+			versions = append(versions, artifactVersion{aws.StringValue(v.Key), aws.StringValue(v.VersionId), aws.TimeValue(v.LastModified)})
+		}
+		for _, v := range page.DeleteMarkers {
+			versions = append(versions, artifactVersion{aws.StringValue(v.Key), aws.StringValue(v.VersionId), aws.TimeValue(v.LastModified)})
+		}
+		return !lastPage
+	})
+	if isAWSErr(err, s3.ErrCodeNoSuchBucket, "") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error listing artifacts of %s: %s", prefix, err)
+	}
+
+	objects := []*s3.ObjectIdentifier{}
+	for _, v := range expiredArtifacts(versions, keep) {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(v.key), VersionId: aws.String(v.version)})
+	}
+	// DeleteObjects takes at most 1000 objects a request
+	for len(objects) > 0 {
+		n := len(objects)
+		if n > 1000 {
+			n = 1000
+		}
+		_, err := conn.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(bucket),
+			Delete: &s3.Delete{Objects: objects[:n], Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return fmt.Errorf("Error deleting artifacts of %s: %s", prefix, err)
+		}
+		objects = objects[n:]
+	}
+	return nil
+}
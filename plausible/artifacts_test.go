@@ -0,0 +1,50 @@
+package plausible
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExpiredArtifacts(t *testing.T) {
+	at := func(day int) time.Time {
+		return time.Date(2026, time.March, day, 12, 0, 0, 0, time.UTC)
+	}
+	v1 := artifactVersion{"app/a.zip", "v1", at(1)}
+	v2 := artifactVersion{"app/b.zip", "v2", at(2)}
+	v3 := artifactVersion{"app/c.zip", "v3", at(3)}
+	v4 := artifactVersion{"app/d.zip", "v4", at(4)}
+	// Uploaded in the same second as v4
+	v4b := artifactVersion{"app/e.zip", "v4b", at(4)}
+
+	cases := []struct {
+		name     string
+		versions []artifactVersion
+		keep     int
+		expired  []artifactVersion
+	}{
+		{name: "none", versions: nil, keep: 2, expired: nil},
+		{name: "fewer than kept", versions: []artifactVersion{v1, v2}, keep: 3, expired: nil},
+		{name: "as many as kept", versions: []artifactVersion{v1, v2}, keep: 2, expired: nil},
+		{name: "oldest expire", versions: []artifactVersion{v1, v2, v3, v4}, keep: 2, expired: []artifactVersion{v2, v1}},
+		{name: "listing order", versions: []artifactVersion{v3, v1, v4, v2}, keep: 2, expired: []artifactVersion{v2, v1}},
+		{name: "keep none", versions: []artifactVersion{v2, v1}, keep: 0, expired: []artifactVersion{v2, v1}},
+		{name: "same time", versions: []artifactVersion{v4, v3, v4b}, keep: 1, expired: []artifactVersion{v4, v3}},
+		{name: "same time listed the other way", versions: []artifactVersion{v4b, v3, v4}, keep: 1, expired: []artifactVersion{v4, v3}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			versions := append([]artifactVersion{}, c.versions...)
+			expired := expiredArtifacts(versions, c.keep)
+			if len(expired) == 0 && len(c.expired) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(expired, c.expired) {
+				t.Fatalf("got %v, want %v", expired, c.expired)
+			}
+			if !reflect.DeepEqual(versions, c.versions) {
+				t.Errorf("the listing was reordered to %v", versions)
+			}
+		})
+	}
+}
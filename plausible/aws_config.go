@@ -6,62 +6,126 @@ import (
 
 	"github.com/aws/aws-sdk-go/aws"
 	awsCredentials "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/aws/aws-sdk-go/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/aws/aws-sdk-go/service/iam"
 	"github.com/aws/aws-sdk-go/service/kinesis"
 	"github.com/aws/aws-sdk-go/service/kinesisanalytics"
 	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/scheduler"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
 	"github.com/mitchellh/go-homedir"
 )
 
 type AWSConfig struct {
-	AppName          string
-	AccessKey        string
-	SecretKey        string
-	CredsFilename    string
-	Profile          string
-	Token            string
-	Partition        string
-	AccountId        string
-	Region           string
-	terraformVersion string
-	CallerName       string
+	AppName           string
+	AccessKey         string
+	SecretKey         string
+	CredsFilename     string
+	Profile           string
+	Token             string
+	Partition         string
+	AccountId         string
+	Region            string
+	terraformVersion  string
+	CallerName        string
+	Tracing           string
+	ArtifactBucket    string
+	ArtifactRetention int
 }
 
 type AWSClient struct {
-	apigatewayconn       *apigateway.APIGateway
-	cloudwatcheventsconn *cloudwatchevents.CloudWatchEvents
-	dynamodbconn         *dynamodb.DynamoDB
-	firehoseconn         *firehose.Firehose
-	kinesisanalyticsconn *kinesisanalytics.KinesisAnalytics
-	kinesisconn          *kinesis.Kinesis
-	lambdaconn           *lambda.Lambda
-	s3conn               *s3.S3
-	snsconn              *sns.SNS
-	sqsconn              *sqs.SQS
-	AppName              string
+	apigatewayconn             *apigateway.APIGateway
+	applicationautoscalingconn *applicationautoscaling.ApplicationAutoScaling
+	cloudwatchconn             *cloudwatch.CloudWatch
+	cloudwatcheventsconn       *cloudwatchevents.CloudWatchEvents
+	dynamodbconn               *dynamodb.DynamoDB
+	ec2conn                    *ec2.EC2
+	ecrconn                    *ecr.ECR
+	firehoseconn               *firehose.Firehose
+	iamconn                    *iam.IAM
+	kinesisanalyticsconn       *kinesisanalytics.KinesisAnalytics
+	kinesisconn                *kinesis.Kinesis
+	lambdaconn                 *lambda.Lambda
+	logsconn                   *cloudwatchlogs.CloudWatchLogs
+	s3conn                     *s3.S3
+	schedulerconn              *scheduler.Scheduler
+	secretsmanagerconn         *secretsmanager.SecretsManager
+	snsconn                    *sns.SNS
+	sqsconn                    *sqs.SQS
+	ssmconn                    *ssm.SSM
+	accountid                  string
+	partition                  string
+	region                     string
+	AppName                    string
+	Tracing                    string
+	ArtifactBucket             string
+	ArtifactRetention          int
 }
 
 func (conf *AWSConfig) Client() (interface{}, error) {
-	sess, _ := GetSession(conf)
+	sess, err := GetSession(conf)
+	if err != nil {
+		return nil, err
+	}
+	if conf.AccountId == "" {
+		// Role and policy ARNs, and the artifact bucket's name, include the
+		// account
+		identity, err := sts.New(sess.Copy()).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up the AWS account: %s", err)
+		}
+		conf.AccountId = aws.StringValue(identity.Account)
+	}
+	if conf.Partition == "" {
+		// ARNs in other partitions, such as China and GovCloud, start
+		// differently
+		conf.Partition = endpoints.AwsPartitionID
+		if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), conf.Region); ok {
+			conf.Partition = p.ID()
+		}
+	}
 	client := &AWSClient{
-		apigatewayconn:       apigateway.New(sess.Copy()),
-		cloudwatcheventsconn: cloudwatchevents.New(sess.Copy()),
-		dynamodbconn:         dynamodb.New(sess.Copy()),
-		firehoseconn:         firehose.New(sess.Copy()),
-		kinesisanalyticsconn: kinesisanalytics.New(sess.Copy()),
-		kinesisconn:          kinesis.New(sess.Copy()),
-		lambdaconn:           lambda.New(sess.Copy()),
-		s3conn:               s3.New(sess.Copy()),
-		snsconn:              sns.New(sess.Copy()),
-		sqsconn:              sqs.New(sess.Copy()),
-		AppName:              conf.AppName,
+		apigatewayconn:             apigateway.New(sess.Copy()),
+		applicationautoscalingconn: applicationautoscaling.New(sess.Copy()),
+		cloudwatchconn:             cloudwatch.New(sess.Copy()),
+		cloudwatcheventsconn:       cloudwatchevents.New(sess.Copy()),
+		dynamodbconn:               dynamodb.New(sess.Copy()),
+		ec2conn:                    ec2.New(sess.Copy()),
+		ecrconn:                    ecr.New(sess.Copy()),
+		firehoseconn:               firehose.New(sess.Copy()),
+		iamconn:                    iam.New(sess.Copy()),
+		kinesisanalyticsconn:       kinesisanalytics.New(sess.Copy()),
+		kinesisconn:                kinesis.New(sess.Copy()),
+		lambdaconn:                 lambda.New(sess.Copy()),
+		logsconn:                   cloudwatchlogs.New(sess.Copy()),
+		s3conn:                     s3.New(sess.Copy()),
+		schedulerconn:              scheduler.New(sess.Copy()),
+		secretsmanagerconn:         secretsmanager.New(sess.Copy()),
+		snsconn:                    sns.New(sess.Copy()),
+		sqsconn:                    sqs.New(sess.Copy()),
+		ssmconn:                    ssm.New(sess.Copy()),
+		accountid:                  conf.AccountId,
+		partition:                  conf.Partition,
+		region:                     conf.Region,
+		AppName:                    conf.AppName,
+		Tracing:                    conf.Tracing,
+		ArtifactBucket:             conf.ArtifactBucket,
+		ArtifactRetention:          conf.ArtifactRetention,
 	}
 
 	return client, nil
@@ -76,8 +140,11 @@ func GetSession(conf *AWSConfig) (*session.Session, error) {
 		},
 		Profile: conf.Profile,
 	}
-	sess, err := session.NewSession()
-	return sess, err
+	sess, err := session.NewSessionWithOptions(*options)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating AWS session: %s", err)
+	}
+	return sess, nil
 }
 
 func GetCredentials(c *AWSConfig) (*awsCredentials.Credentials, error) {
@@ -96,7 +163,8 @@ func GetCredentials(c *AWSConfig) (*awsCredentials.Credentials, error) {
 		}},
 		&awsCredentials.EnvProvider{},
 		&awsCredentials.SharedCredentialsProvider{
-			Profile: c.Profile,
+			Filename: sharedCredentialsFilename,
+			Profile:  c.Profile,
 		},
 	}
 
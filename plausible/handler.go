@@ -0,0 +1,199 @@
+package plausible
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A function's handler is checked against its source when planning, so that
+// a misspelt module or function fails the plan instead of the first
+// invocation. Files are looked up in the source directory, falling back to
+// the packaged lambda.zip inside it. Runtimes whose handlers name compiled
+// code, like Java and .NET, are not checked.
+
+// sourceArchiveName is the package inside a source directory
+const sourceArchiveName = "lambda.zip"
+
+// handlerLanguage describes how a runtime family resolves handlers
+type handlerLanguage struct {
+	extensions []string
+	// definitions returns the patterns that define the named function
+	definitions func(name string) []string
+}
+
+var handlerLanguages = map[string]handlerLanguage{
+	"python": {
+		extensions: []string{".py"},
+		definitions: func(name string) []string {
+			return []string{
+				fmt.Sprintf(`(?m)^(async\s+)?def\s+%s\s*\(`, name),
+				fmt.Sprintf(`(?m)^%s\s*=`, name),
+				// from app.main import handler, or import impl as handler
+				fmt.Sprintf(`(?m)^\s*from\s+\S+\s+import\s+(\(\s*)?([\w\s,]*,\s*)?%s\s*(,|\)|#|$)`, name),
+				fmt.Sprintf(`(?m)^\s*(from\s+\S+\s+)?import\s+[^\n#]*\bas\s+%s\b`, name),
+			}
+		},
+	},
+	"nodejs": {
+		extensions: []string{".js", ".mjs", ".cjs"},
+		definitions: func(name string) []string {
+			return []string{
+				fmt.Sprintf(`\bexports\.%s\s*=`, name),
+				fmt.Sprintf(`\bexport\s+(async\s+)?(function\s*\*?|const|let|var)\s*%s\b`, name),
+				fmt.Sprintf(`\bexport\s*\{[^}]*\b%s\b[^}]*\}`, name),
+				// module.exports = { handler } or { handler: ... }, whose values
+				// may span lines and contain braces of their own
+				fmt.Sprintf(`(?s)\bmodule\.exports\s*=\s*\{(.*[{,])?\s*(async\s+)?%s\s*[:,}(]`, name),
+				// Modules re-exporting another module wholesale are not
+				// followed, so they are taken to define the handler
+				`\bmodule\.exports\s*=\s*require\s*\(`,
+				`\bexport\s*\*\s*from\b`,
+			}
+		},
+	},
+	"ruby": {
+		extensions: []string{".rb"},
+		definitions: func(name string) []string {
+			return []string{
+				fmt.Sprintf(`(?m)^\s*def\s+(self\.)?%s\b`, name),
+			}
+		},
+	},
+}
+
+func runtimeLanguage(runtime string) string {
+	for _, prefix := range []string{"python", "nodejs", "ruby", "provided", "go"} {
+		if strings.HasPrefix(runtime, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// readSourceFile returns a file from the source directory or its package,
+// and whether it was found
+func readSourceFile(source string, name string) ([]byte, bool, error) {
+	content, err := ioutil.ReadFile(filepath.Join(source, filepath.FromSlash(name)))
+	if err == nil {
+		return content, true, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, false, err
+	}
+
+	archive, err := zip.OpenReader(filepath.Join(source, sourceArchiveName))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("Error opening %s: %s", sourceArchiveName, err)
+	}
+	defer archive.Close()
+	for _, f := range archive.File {
+		if f.Name != name {
+			continue
+		}
+		r, err := f.Open()
+		if err != nil {
+			return nil, false, fmt.Errorf("Error reading %s from %s: %s", name, sourceArchiveName, err)
+		}
+		defer r.Close()
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, false, fmt.Errorf("Error reading %s from %s: %s", name, sourceArchiveName, err)
+		}
+		return content, true, nil
+	}
+	return nil, false, nil
+}
+
+// sourceExists reports whether there is anything at source to check yet. It
+// may be built later in the apply.
+func sourceExists(source string) bool {
+	_, err := os.Stat(source)
+	return err == nil
+}
+
+// validateFunctionHandler checks that the handler's module exists in the
+// source and defines the handler function, or that a custom runtime's source
+// has a bootstrap
+func validateFunctionHandler(diff *schema.ResourceDiff) error {
+	if !diff.NewValueKnown("source") || !diff.NewValueKnown("handler") {
+		return nil
+	}
+	// Built functions have their bootstrap produced by the build
+	if _, ok := diff.GetOk("build"); ok {
+		return nil
+	}
+	source := diff.Get("source").(string)
+	if !sourceExists(source) {
+		return nil
+	}
+	runtime := diff.Get("runtime").(string)
+	handler := diff.Get("handler").(string)
+
+	switch language := runtimeLanguage(runtime); language {
+	case "provided":
+		return requireSourceFile(source, "bootstrap", runtime)
+	case "go":
+		return requireSourceFile(source, handler, runtime)
+	case "":
+		return nil
+	case "ruby":
+		// Handlers on classes name a module first, which is not checked
+		if strings.Contains(handler, "::") {
+			return nil
+		}
+		fallthrough
+	default:
+		return validateHandlerDefinition(source, handler, runtime, handlerLanguages[language])
+	}
+}
+
+func requireSourceFile(source string, name string, runtime string) error {
+	_, found, err := readSourceFile(source, name)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("handler: %s functions need a %s file in %s", runtime, name, source)
+	}
+	return nil
+}
+
+// validateHandlerDefinition checks a module.function handler, whose module
+// may be a path relative to the source
+func validateHandlerDefinition(source string, handler string, runtime string, language handlerLanguage) error {
+	i := strings.LastIndex(handler, ".")
+	if i <= 0 || i == len(handler)-1 {
+		return fmt.Errorf("handler: %q is not of the form module.function", handler)
+	}
+	module, name := handler[:i], handler[i+1:]
+	if runtimeLanguage(runtime) == "python" {
+		module = strings.ReplaceAll(module, ".", "/")
+	}
+
+	for _, extension := range language.extensions {
+		content, found, err := readSourceFile(source, module+extension)
+		if err != nil {
+			return err
+		}
+		if !found {
+			continue
+		}
+		for _, pattern := range language.definitions(regexp.QuoteMeta(name)) {
+			if regexp.MustCompile(pattern).Match(content) {
+				return nil
+			}
+		}
+		return fmt.Errorf("handler: %s%s in %s does not define %s", module, extension, source, name)
+	}
+	return fmt.Errorf("handler: no %s file for module %s in %s", strings.Join(language.extensions, " or "), module, source)
+}
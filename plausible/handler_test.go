@@ -0,0 +1,271 @@
+package plausible
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeSource writes files into a new source directory
+func writeSource(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "plausible-source")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestValidateHandlerDefinition(t *testing.T) {
+	cases := []struct {
+		name    string
+		runtime string
+		handler string
+		files   map[string]string
+		err     string
+	}{
+		{
+			name:    "python def",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "def handler(event, context):\n    pass\n"},
+		},
+		{
+			name:    "python async def",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "async def handler (event, context):\n    pass\n"},
+		},
+		{
+			name:    "python assignment",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "handler = make_handler()\n"},
+		},
+		{
+			name:    "python package module",
+			runtime: "python3.13",
+			handler: "app.main.handler",
+			files:   map[string]string{"app/main.py": "def handler(event, context):\n    pass\n"},
+		},
+		{
+			name:    "python import",
+			runtime: "python3.13",
+			handler: "lambda_function.handler",
+			files:   map[string]string{"lambda_function.py": "from app.main import handler\n"},
+		},
+		{
+			name:    "python import among others",
+			runtime: "python3.13",
+			handler: "lambda_function.handler",
+			files:   map[string]string{"lambda_function.py": "from app.main import setup, handler  # noqa\n"},
+		},
+		{
+			name:    "python parenthesised import",
+			runtime: "python3.13",
+			handler: "lambda_function.handler",
+			files:   map[string]string{"lambda_function.py": "from app.main import (\n    setup,\n    handler,\n)\n"},
+		},
+		{
+			name:    "python relative import as",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "from .views import handler as handler\n"},
+		},
+		{
+			name:    "python import renamed to handler",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "from .views import dispatch as handler\n"},
+		},
+		{
+			name:    "python import renamed away",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "from .views import handler as dispatch\n"},
+			err:     "app.py in",
+		},
+		{
+			name:    "python call is not a definition",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"app.py": "def main():\n    handler(None, None)\n"},
+			err:     "does not define handler",
+		},
+		{
+			name:    "python missing module",
+			runtime: "python3.13",
+			handler: "app.handler",
+			files:   map[string]string{"main.py": "def handler(event, context):\n    pass\n"},
+			err:     "no .py file for module app",
+		},
+		{
+			name:    "nodejs exports",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.js": "exports.handler = async (event) => {}\n"},
+		},
+		{
+			name:    "nodejs export function",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.mjs": "export async function handler(event) {}\n"},
+		},
+		{
+			name:    "nodejs export const",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.mjs": "export const handler = async (event) => {}\n"},
+		},
+		{
+			name:    "nodejs export list",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.mjs": "const handler = () => {}\nexport { setup, handler }\n"},
+		},
+		{
+			name:    "nodejs module.exports shorthand",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.js": "module.exports = { handler }\n"},
+		},
+		{
+			name:    "nodejs module.exports multi-line",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files: map[string]string{"index.js": "module.exports = {\n" +
+				"  setup: () => { return {} },\n" +
+				"  async handler(event) {\n" +
+				"    return {}\n" +
+				"  },\n" +
+				"}\n"},
+		},
+		{
+			name:    "nodejs module.exports property",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.js": "module.exports = {\n  handler: require('./impl').run,\n}\n"},
+		},
+		{
+			name:    "nodejs module.exports require",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.js": "module.exports = require(\"./impl\")\n"},
+		},
+		{
+			name:    "nodejs export star",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.mjs": "export * from './impl.mjs'\n"},
+		},
+		{
+			name:    "nodejs module.exports without handler",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.js": "module.exports = {\n  setup,\n  handlerV2: () => {},\n}\n"},
+			err:     "index.js in",
+		},
+		{
+			name:    "nodejs other export",
+			runtime: "nodejs22.x",
+			handler: "index.handler",
+			files:   map[string]string{"index.js": "exports.handlerV2 = async (event) => {}\n"},
+			err:     "does not define handler",
+		},
+		{
+			name:    "ruby def",
+			runtime: "ruby3.3",
+			handler: "function.handler",
+			files:   map[string]string{"function.rb": "def handler(event:, context:)\nend\n"},
+		},
+		{
+			name:    "ruby self def",
+			runtime: "ruby3.3",
+			handler: "function.handler",
+			files:   map[string]string{"function.rb": "module Function\n  def self.handler(event:, context:)\n  end\nend\n"},
+		},
+		{
+			name:    "not module.function",
+			runtime: "python3.13",
+			handler: "handler",
+			files:   map[string]string{"handler.py": "def handler(event, context):\n    pass\n"},
+			err:     "is not of the form module.function",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			source := writeSource(t, c.files)
+			defer os.RemoveAll(source)
+
+			err := validateHandlerDefinition(source, c.handler, c.runtime, handlerLanguages[runtimeLanguage(c.runtime)])
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error containing %q", c.err)
+			}
+			if !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("error %q does not contain %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidateHandlerDefinitionArchive(t *testing.T) {
+	source := writeSource(t, nil)
+	defer os.RemoveAll(source)
+
+	f, err := os.Create(filepath.Join(source, sourceArchiveName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	entry, err := w.Create("app/main.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry.Write([]byte("def handler(event, context):\n    pass\n"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	python := handlerLanguages["python"]
+	if err := validateHandlerDefinition(source, "app.main.handler", "python3.13", python); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := validateHandlerDefinition(source, "app.other.handler", "python3.13", python); err == nil {
+		t.Fatal("expected an error for a module missing from the archive")
+	}
+}
+
+func TestRuntimeLanguage(t *testing.T) {
+	cases := map[string]string{
+		"python3.13":      "python",
+		"nodejs22.x":      "nodejs",
+		"ruby3.4":         "ruby",
+		"provided.al2023": "provided",
+		"go1.x":           "go",
+		"java21":          "",
+		"dotnet8":         "",
+	}
+	for runtime, language := range cases {
+		if got := runtimeLanguage(runtime); got != language {
+			t.Errorf("runtimeLanguage(%q) = %q, want %q", runtime, got, language)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package plausible
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/iam"
+)
+
+type iamPolicyDocument struct {
+	Version   string
+	Statement []*iamPolicyStatement
+}
+
+type iamPolicyStatement struct {
+	Sid       string `json:",omitempty"`
+	Effect    string
+	Principal map[string]string `json:",omitempty"`
+	Action    []string
+	Resource  []string                     `json:",omitempty"`
+	Condition map[string]map[string]string `json:",omitempty"`
+}
+
+func newIamPolicyDocument(statements []*iamPolicyStatement) *iamPolicyDocument {
+	return &iamPolicyDocument{
+		Version:   "2012-10-17",
+		Statement: statements,
+	}
+}
+
+func (p *iamPolicyDocument) String() (string, error) {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding IAM policy: %s", err)
+	}
+	return string(b), nil
+}
+
+// roleNameFromArn returns the name of a role, without its path
+func roleNameFromArn(roleArn string) (string, error) {
+	parsed, err := arn.Parse(roleArn)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing role ARN %q: %s", roleArn, err)
+	}
+	parts := strings.Split(parsed.Resource, "/")
+	return parts[len(parts)-1], nil
+}
+
+// putRoleInlinePolicy replaces the named inline policy on a role, or removes
+// it when there are no statements left to grant
+func putRoleInlinePolicy(iamconn *iam.IAM, roleArn string, policyName string, statements []*iamPolicyStatement) error {
+	roleName, err := roleNameFromArn(roleArn)
+	if err != nil {
+		return err
+	}
+
+	if len(statements) == 0 {
+		return deleteRoleInlinePolicy(iamconn, roleArn, policyName)
+	}
+
+	policy, err := newIamPolicyDocument(statements).String()
+	if err != nil {
+		return err
+	}
+	log.Printf("[DEBUG] Putting IAM role policy %s on %s: %s", policyName, roleName, policy)
+	_, err = iamconn.PutRolePolicy(&iam.PutRolePolicyInput{
+		RoleName:       aws.String(roleName),
+		PolicyName:     aws.String(policyName),
+		PolicyDocument: aws.String(policy),
+	})
+	if err != nil {
+		return fmt.Errorf("Error putting IAM role policy %s: %s", policyName, err)
+	}
+	return nil
+}
+
+func deleteRoleInlinePolicy(iamconn *iam.IAM, roleArn string, policyName string) error {
+	roleName, err := roleNameFromArn(roleArn)
+	if err != nil {
+		return err
+	}
+
+	_, err = iamconn.DeleteRolePolicy(&iam.DeleteRolePolicyInput{
+		RoleName:   aws.String(roleName),
+		PolicyName: aws.String(policyName),
+	})
+	if err != nil && !isAWSErr(err, iam.ErrCodeNoSuchEntityException, "") {
+		return fmt.Errorf("Error deleting IAM role policy %s: %s", policyName, err)
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package plausible
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// An object store's keys are laid out as a tree of key components, one per
+// path segment. A component with an enum takes one of a fixed set of values,
+// a component with a regex takes any value matching it, and a component with
+// neither is the literal segment of its own name. Terminal components end a
+// key.
+type keyComponent struct {
+	Name     string   `json:"name"`
+	Parent   string   `json:"parent,omitempty"`
+	Terminal bool     `json:"terminal,omitempty"`
+	Regex    string   `json:"regex,omitempty"`
+	Enum     []string `json:"enum,omitempty"`
+}
+
+// keyPathFilter is a single S3 notification key filter
+type keyPathFilter struct {
+	Prefix string
+	Suffix string
+}
+
+// keyPathState accumulates the filter for one branch of a key path as its
+// segments are matched against the tree
+type keyPathState struct {
+	prefix   []string
+	wildcard bool
+	head     string
+	tail     string
+	suffix   []string
+	last     *keyComponent
+}
+
+func expandKeyComponents(set *schema.Set) []keyComponent {
+	components := []keyComponent{}
+	for _, v := range set.List() {
+		c := v.(map[string]interface{})
+		component := keyComponent{
+			Name:     c["name"].(string),
+			Parent:   c["parent"].(string),
+			Terminal: c["terminal"].(bool),
+			Regex:    c["regex"].(string),
+		}
+		if enum, ok := c["enum"].(*schema.Set); ok {
+			for _, e := range enum.List() {
+				component.Enum = append(component.Enum, e.(string))
+			}
+			sort.Strings(component.Enum)
+		}
+		components = append(components, component)
+	}
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+	return components
+}
+
+func encodeKeyComponents(components []keyComponent) (string, error) {
+	b, err := json.Marshal(components)
+	if err != nil {
+		return "", fmt.Errorf("Error encoding key components: %s", err)
+	}
+	return string(b), nil
+}
+
+func decodeKeyComponents(s string) ([]keyComponent, error) {
+	components := []keyComponent{}
+	if s == "" {
+		return components, nil
+	}
+	if err := json.Unmarshal([]byte(s), &components); err != nil {
+		return nil, fmt.Errorf("Error decoding key components: %s", err)
+	}
+	return components, nil
+}
+
+// validateKeyComponents checks that the components form a tree
+func validateKeyComponents(components []keyComponent) error {
+	names := map[string]bool{}
+	for _, c := range components {
+		if c.Name == "" {
+			return fmt.Errorf("every key_component must have a name")
+		}
+		if names[c.Name] {
+			return fmt.Errorf("key_component %q is declared more than once", c.Name)
+		}
+		names[c.Name] = true
+		if c.Regex != "" && len(c.Enum) > 0 {
+			return fmt.Errorf("key_component %q cannot have both a regex and an enum", c.Name)
+		}
+		if c.Regex != "" {
+			if _, err := regexp.Compile(c.Regex); err != nil {
+				return fmt.Errorf("key_component %q has an invalid regex: %s", c.Name, err)
+			}
+		}
+	}
+	for _, c := range components {
+		if c.Parent != "" && !names[c.Parent] {
+			return fmt.Errorf("key_component %q has parent %q, which is not declared", c.Name, c.Parent)
+		}
+	}
+	return nil
+}
+
+func validateKeyPath(val interface{}, key string) (warns []string, errs []error) {
+	path := val.(string)
+	if path == "" || strings.HasPrefix(path, "/") || strings.HasSuffix(path, "/") {
+		errs = append(errs, fmt.Errorf("%q: key path %q must be segments separated by '/', without a leading or trailing '/'", key, path))
+		return
+	}
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" {
+			errs = append(errs, fmt.Errorf("%q: key path %q has an empty segment", key, path))
+		} else if strings.Count(segment, "*") > 1 {
+			errs = append(errs, fmt.Errorf("%q: segment %q of key path %q has more than one '*'", key, segment, path))
+		}
+	}
+	return
+}
+
+// compileKeyPath turns a key path such as tenant/*/invoices into S3 key
+// filters. A '*' on an enum component expands into one filter per value. A
+// '*' on a regex component ends the prefix, and whatever follows it must
+// reach the end of the key to be expressed as a suffix.
+func compileKeyPath(path string, components []keyComponent) ([]keyPathFilter, error) {
+	if err := validateKeyComponents(components); err != nil {
+		return nil, err
+	}
+	if len(components) == 0 {
+		return nil, fmt.Errorf("key path %q requires the object store to declare key_component blocks", path)
+	}
+	filters, err := compileKeyPathSegments(path, strings.Split(path, "/"), components, "", keyPathState{})
+	if err != nil {
+		return nil, err
+	}
+
+	// Expanding several wildcards can arrive at the same filter more than once
+	unique := []keyPathFilter{}
+	seen := map[keyPathFilter]bool{}
+	for _, f := range filters {
+		if !seen[f] {
+			seen[f] = true
+			unique = append(unique, f)
+		}
+	}
+	return unique, nil
+}
+
+func compileKeyPathSegments(path string, segments []string, components []keyComponent, parent string, state keyPathState) ([]keyPathFilter, error) {
+	if len(segments) == 0 {
+		return finishKeyPath(path, state)
+	}
+	segment := segments[0]
+
+	filters := []keyPathFilter{}
+	matched := false
+	for i := range components {
+		c := &components[i]
+		if c.Parent != parent {
+			continue
+		}
+
+		values, wildcard, err := matchKeyComponent(path, c, segment, len(segments) == 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) == 0 && !wildcard {
+			continue
+		}
+		matched = true
+
+		if wildcard {
+			if state.wildcard {
+				return nil, fmt.Errorf("key path %q has more than one wildcard on a regex component, which cannot be expressed as an S3 filter", path)
+			}
+			next := state
+			next.prefix = append([]string{}, state.prefix...)
+			next.wildcard = true
+			star := strings.Index(segment, "*")
+			next.head, next.tail = segment[:star], segment[star+1:]
+			next.last = c
+			f, err := compileKeyPathSegments(path, segments[1:], components, c.Name, next)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f...)
+			continue
+		}
+
+		for _, value := range values {
+			next := state
+			if state.wildcard {
+				next.suffix = append(append([]string{}, state.suffix...), value)
+			} else {
+				next.prefix = append(append([]string{}, state.prefix...), value)
+			}
+			next.last = c
+			f, err := compileKeyPathSegments(path, segments[1:], components, c.Name, next)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f...)
+		}
+	}
+
+	if !matched {
+		under := "the root of the key tree"
+		if parent != "" {
+			under = fmt.Sprintf("key_component %q", parent)
+		}
+		return nil, fmt.Errorf("segment %q of key path %q does not match any key_component under %s", segment, path, under)
+	}
+	return filters, nil
+}
+
+// matchKeyComponent returns the literal values that a segment selects from a
+// component, or whether it is a wildcard over a regex component
+func matchKeyComponent(path string, c *keyComponent, segment string, last bool) ([]string, bool, error) {
+	switch {
+	case len(c.Enum) > 0:
+		if segment == "*" {
+			return c.Enum, false, nil
+		}
+		for _, v := range c.Enum {
+			if v == segment {
+				return []string{v}, false, nil
+			}
+		}
+	case c.Regex != "":
+		if strings.Contains(segment, "*") {
+			if segment != "*" && !(c.Terminal && last) {
+				return nil, false, fmt.Errorf("segment %q of key path %q can only combine '*' with text in the last segment of a terminal key_component", segment, path)
+			}
+			return nil, true, nil
+		}
+		if regexp.MustCompile("^(?:" + c.Regex + ")$").MatchString(segment) {
+			return []string{segment}, false, nil
+		}
+	default:
+		if segment == c.Name || segment == "*" {
+			return []string{c.Name}, false, nil
+		}
+	}
+	return nil, false, nil
+}
+
+func finishKeyPath(path string, state keyPathState) ([]keyPathFilter, error) {
+	if !state.wildcard {
+		prefix := strings.Join(state.prefix, "/")
+		if !state.last.Terminal {
+			prefix += "/"
+		}
+		return []keyPathFilter{{Prefix: prefix}}, nil
+	}
+
+	prefix := strings.Join(state.prefix, "/")
+	if prefix != "" {
+		prefix += "/"
+	}
+	prefix += state.head
+
+	suffix := state.tail
+	if len(state.suffix) > 0 {
+		suffix += "/" + strings.Join(state.suffix, "/")
+	}
+	if suffix != "" && !state.last.Terminal {
+		return nil, fmt.Errorf("key path %q continues after a wildcard on a regex component without reaching a terminal key_component, so it cannot be expressed as an S3 suffix filter", path)
+	}
+	return []keyPathFilter{{Prefix: prefix, Suffix: suffix}}, nil
+}
+
+// compileKeyPrefix checks a prefix template such as
+// tenant/acme/!{timestamp:yyyy}/ against the tree and returns the prefix to
+// write objects under. Literal and enum components must be given one of their
+// values, while regex components may also take a Firehose expression, which
+// is filled in as each object is delivered.
+func compileKeyPrefix(path string, components []keyComponent) (string, error) {
+	if err := validateKeyComponents(components); err != nil {
+		return "", err
+	}
+	if len(components) == 0 {
+		return "", fmt.Errorf("prefix %q requires the object store to declare key_component blocks", path)
+	}
+
+	parent := ""
+	var last *keyComponent
+	for _, segment := range strings.Split(strings.TrimSuffix(path, "/"), "/") {
+		if strings.Contains(segment, "*") {
+			return "", fmt.Errorf("prefix %q cannot contain '*'", path)
+		}
+		expression := strings.Contains(segment, "!{")
+
+		var match *keyComponent
+		for i := range components {
+			c := &components[i]
+			if c.Parent != parent {
+				continue
+			}
+			if c.Regex != "" && expression {
+				match = c
+				break
+			}
+			values, _, err := matchKeyComponent(path, c, segment, false)
+			if err != nil {
+				return "", err
+			}
+			if len(values) > 0 {
+				match = c
+				break
+			}
+		}
+		if match == nil {
+			under := "the root of the key tree"
+			if parent != "" {
+				under = fmt.Sprintf("key_component %q", parent)
+			}
+			return "", fmt.Errorf("segment %q of prefix %q does not match any key_component under %s", segment, path, under)
+		}
+		parent = match.Name
+		last = match
+	}
+
+	if last.Terminal {
+		return "", fmt.Errorf("prefix %q ends at terminal key_component %q, which leaves no room for object names", path, last.Name)
+	}
+	return strings.TrimSuffix(path, "/") + "/", nil
+}
@@ -0,0 +1,210 @@
+package plausible
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testKeyComponents describes tenant/<tenant_id>/<kind>/<file> and
+// logs/<date>/<entry>, sorted by name as expandKeyComponents leaves them
+var testKeyComponents = []keyComponent{
+	{Name: "date", Parent: "logs", Regex: `\d{4}-\d{2}-\d{2}`},
+	{Name: "entry", Parent: "date", Terminal: true, Regex: `.+`},
+	{Name: "file", Parent: "kind", Terminal: true, Regex: `[^/]+`},
+	{Name: "kind", Parent: "tenant_id", Enum: []string{"invoices", "receipts"}},
+	{Name: "logs"},
+	{Name: "tenant"},
+	{Name: "tenant_id", Parent: "tenant", Regex: `[a-z0-9-]+`},
+}
+
+func TestCompileKeyPath(t *testing.T) {
+	cases := []struct {
+		path    string
+		filters []keyPathFilter
+		err     string
+	}{
+		{
+			path:    "tenant/acme/invoices",
+			filters: []keyPathFilter{{Prefix: "tenant/acme/invoices/"}},
+		},
+		{
+			path:    "tenant/acme/*",
+			filters: []keyPathFilter{{Prefix: "tenant/acme/invoices/"}, {Prefix: "tenant/acme/receipts/"}},
+		},
+		{
+			path:    "*",
+			filters: []keyPathFilter{{Prefix: "logs/"}, {Prefix: "tenant/"}},
+		},
+		{
+			path:    "tenant/*",
+			filters: []keyPathFilter{{Prefix: "tenant/"}},
+		},
+		{
+			path:    "tenant/acme/invoices/*.pdf",
+			filters: []keyPathFilter{{Prefix: "tenant/acme/invoices/", Suffix: ".pdf"}},
+		},
+		{
+			path:    "tenant/acme/invoices/2024-*",
+			filters: []keyPathFilter{{Prefix: "tenant/acme/invoices/2024-"}},
+		},
+		{
+			path:    "tenant/acme/invoices/a.pdf",
+			filters: []keyPathFilter{{Prefix: "tenant/acme/invoices/a.pdf"}},
+		},
+		{
+			path: "logs/*/*",
+			err:  "more than one wildcard on a regex component",
+		},
+		{
+			path: "tenant/*/invoices",
+			err:  "without reaching a terminal key_component",
+		},
+		{
+			path: "tenant/*/invoices/*.pdf",
+			err:  "more than one wildcard on a regex component",
+		},
+		{
+			path: "tenant/ac*me/invoices",
+			err:  "can only combine '*' with text",
+		},
+		{
+			path: "tenant/acme/refunds",
+			err:  `does not match any key_component under key_component "tenant_id"`,
+		},
+		{
+			path: "archive",
+			err:  "does not match any key_component under the root of the key tree",
+		},
+		{
+			path: "tenant/Acme",
+			err:  `under key_component "tenant"`,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			filters, err := compileKeyPath(c.path, testKeyComponents)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got error %v, want one containing %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(filters, c.filters) {
+				t.Fatalf("got %v, want %v", filters, c.filters)
+			}
+		})
+	}
+}
+
+func TestCompileKeyPathWithoutComponents(t *testing.T) {
+	if _, err := compileKeyPath("tenant", nil); err == nil {
+		t.Fatal("expected an error without key components")
+	}
+}
+
+func TestCompileKeyPrefix(t *testing.T) {
+	cases := []struct {
+		path   string
+		prefix string
+		err    string
+	}{
+		{path: "tenant/acme/invoices/", prefix: "tenant/acme/invoices/"},
+		{path: "tenant/acme/invoices", prefix: "tenant/acme/invoices/"},
+		{path: "tenant/!{partitionKeyFromQuery:tenant}/receipts/", prefix: "tenant/!{partitionKeyFromQuery:tenant}/receipts/"},
+		{path: "logs/!{timestamp:yyyy-MM-dd}/", prefix: "logs/!{timestamp:yyyy-MM-dd}/"},
+		{path: "tenant/acme/invoices/a.pdf", err: "ends at terminal key_component"},
+		{path: "tenant/*/", err: "cannot contain '*'"},
+		{path: "tenant/acme/refunds/", err: "does not match any key_component"},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			prefix, err := compileKeyPrefix(c.path, testKeyComponents)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("got error %v, want one containing %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if prefix != c.prefix {
+				t.Fatalf("got %q, want %q", prefix, c.prefix)
+			}
+		})
+	}
+}
+
+func TestValidateKeyComponents(t *testing.T) {
+	cases := []struct {
+		name       string
+		components []keyComponent
+		err        string
+	}{
+		{name: "tree", components: testKeyComponents},
+		{name: "unnamed", components: []keyComponent{{}}, err: "must have a name"},
+		{name: "duplicate", components: []keyComponent{{Name: "a"}, {Name: "a"}}, err: "declared more than once"},
+		{name: "regex and enum", components: []keyComponent{{Name: "a", Regex: ".*", Enum: []string{"b"}}}, err: "both a regex and an enum"},
+		{name: "invalid regex", components: []keyComponent{{Name: "a", Regex: "("}}, err: "invalid regex"},
+		{name: "missing parent", components: []keyComponent{{Name: "a", Parent: "b"}}, err: "which is not declared"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := validateKeyComponents(c.components)
+			if c.err == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Fatalf("got error %v, want one containing %q", err, c.err)
+			}
+		})
+	}
+}
+
+func TestValidateKeyPath(t *testing.T) {
+	cases := map[string]bool{
+		"tenant":              true,
+		"tenant/*/invoices":   true,
+		"tenant/acme/*.pdf":   true,
+		"":                    false,
+		"/tenant":             false,
+		"tenant/":             false,
+		"tenant//invoices":    false,
+		"tenant/*/in*voi*ces": false,
+	}
+	for path, valid := range cases {
+		_, errs := validateKeyPath(path, "key_path")
+		if valid && len(errs) > 0 {
+			t.Errorf("%q: unexpected errors: %v", path, errs)
+		}
+		if !valid && len(errs) == 0 {
+			t.Errorf("%q: expected an error", path)
+		}
+	}
+}
+
+func TestKeyComponentsEncoding(t *testing.T) {
+	encoded, err := encodeKeyComponents(testKeyComponents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeKeyComponents(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, testKeyComponents) {
+		t.Fatalf("got %v, want %v", decoded, testKeyComponents)
+	}
+
+	empty, err := decodeKeyComponents("")
+	if err != nil || len(empty) != 0 {
+		t.Fatalf("got %v, %v for an empty encoding", empty, err)
+	}
+}
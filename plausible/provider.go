@@ -2,6 +2,7 @@ package plausible
 
 import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
 // Provider -
@@ -24,15 +25,35 @@ func Provider() *schema.Provider {
 				Required:    true,
 				Description: "The name of this app, which may be used to uniquely identify resources",
 			},
+			"tracing": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "PassThrough",
+				ValidateFunc: validateTracingMode,
+				Description:  "The tracing mode, Active or PassThrough, of functions and APIs that do not set their own",
+			},
+			"artifact_bucket": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The bucket packages too large to upload directly are deployed from, instead of one created for the app",
+			},
+			"artifact_retention": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      10,
+				ValidateFunc: validation.IntAtLeast(1),
+				Description:  "The number of uploaded packages kept for each function",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
 			"plausible_function":       resourceFunction(),
 			"plausible_http_api":       resourceHttpApi(),
 			"plausible_object_store":   resourceObjectStore(),
 			"plausible_keyvalue_store": resourceKeyValueStore(),
+			"plausible_layer":          resourceLayer(),
+			"plausible_publisher":      resourcePublisher(),
 			// "plausible_stream_analytics": resourceStreamAnalytics(),
 			// "plausible_file_store": resourceFileStore(),
-			// "plausible_publisher":        resourcePublisher(),
 			// "plausible_eventbus":         resourceEventBus(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{},
@@ -53,10 +74,13 @@ func Provider() *schema.Provider {
 
 func providerConfigure(d *schema.ResourceData, terraformVersion string) (interface{}, error) {
 	config := AWSConfig{
-		AppName:          d.Get("app_name").(string),
-		Region:           d.Get("region").(string),
-		terraformVersion: terraformVersion,
-		CallerName:       "Plausible|AWS Provider",
+		AppName:           d.Get("app_name").(string),
+		Region:            d.Get("region").(string),
+		Tracing:           d.Get("tracing").(string),
+		ArtifactBucket:    d.Get("artifact_bucket").(string),
+		ArtifactRetention: d.Get("artifact_retention").(int),
+		terraformVersion:  terraformVersion,
+		CallerName:        "Plausible|AWS Provider",
 	}
 
 	return config.Client()
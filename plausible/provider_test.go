@@ -0,0 +1,27 @@
+package plausible
+
+import (
+	"testing"
+)
+
+func TestProvider(t *testing.T) {
+	if err := Provider().InternalValidate(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestProviderResources(t *testing.T) {
+	resources := Provider().ResourcesMap
+	for _, name := range []string{
+		"plausible_function",
+		"plausible_http_api",
+		"plausible_object_store",
+		"plausible_keyvalue_store",
+		"plausible_layer",
+		"plausible_publisher",
+	} {
+		if _, ok := resources[name]; !ok {
+			t.Errorf("resource %s is not registered", name)
+		}
+	}
+}
@@ -12,43 +12,53 @@ import (
 )
 
 type RegistryItem struct {
-	Id        string
-	Type      string
-	CreatedAt string
-	Triggers  []*map[string]string
+	Id         string
+	Type       string
+	CreatedAt  string
+	Triggers   []*map[string]string
+	Attributes map[string]string
 }
 
 // Add or update a registry item
 func registryPut(appName string, id string, _type string, triggers []*map[string]string) {
+	item := RegistryItem{
+		Id:   id,
+		Type: _type,
+	}
+
+	if triggers != nil {
+		item.Triggers = triggers
+	}
+
+	err := registryPutItem(appName, &item)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+}
+
+// Add or update a registry item, including attributes that other resources
+// need to look up, such as an object store's key components
+func registryPutItem(appName string, item *RegistryItem) error {
 	sess := session.Must(session.NewSessionWithOptions(session.Options{
 		SharedConfigState: session.SharedConfigEnable,
 	}))
 	svc := dynamodb.New(sess)
 
 	t := time.Now().UTC()
-	createdAt := t.Format("20060102150405")
-
-	item := RegistryItem{
-		Id:        id,
-		Type:      _type,
-		CreatedAt: createdAt,
-	}
+	item.CreatedAt = t.Format("20060102150405")
 
-	if triggers != nil {
-		item.Triggers = triggers
+	av, err := dynamodbattribute.MarshalMap(item)
+	if err != nil {
+		return fmt.Errorf("Failed to marshal registry item %q: %s", item.Id, err)
 	}
 
-	av, _ := dynamodbattribute.MarshalMap(item)
-
 	tableName := TableName(appName)
 	input := &dynamodb.PutItemInput{
 		Item:      av,
 		TableName: aws.String(tableName),
 	}
-	_, err := svc.PutItem(input)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
+	_, err = svc.PutItem(input)
+	return err
 }
 
 func registryGet(appName string, id string) (*RegistryItem, error) {
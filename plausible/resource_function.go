@@ -2,19 +2,13 @@ package plausible
 
 import (
 	"context"
-	"fmt"
 	"io/ioutil"
-	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/arn"
-	"github.com/aws/aws-sdk-go/service/apigateway"
-	events "github.com/aws/aws-sdk-go/service/cloudwatchevents"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/firehose"
 	"github.com/aws/aws-sdk-go/service/lambda"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/aws/aws-sdk-go/service/sns"
-	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/hashicorp/go-cty/cty"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
@@ -28,10 +22,69 @@ func resourceFunction() *schema.Resource {
 		ReadContext:   resourceFunctionRead,
 		UpdateContext: resourceFunctionUpdate,
 		DeleteContext: resourceFunctionDelete,
+		CustomizeDiff: resourceFunctionCustomizeDiff,
 		Schema: map[string]*schema.Schema{
 			"source": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ExactlyOneOf: []string{"source", "image_uri"},
+			},
+			"vpc": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"subnet_ids": &schema.Schema{
+							Type:     schema.TypeSet,
+							Required: true,
+							MinItems: 1,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"security_group_ids": &schema.Schema{
+							Type:     schema.TypeSet,
+							Required: true,
+							MinItems: 1,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"ipv6_allowed_for_dual_stack": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"vpc_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"image_uri": &schema.Schema{
 				Type:     schema.TypeString,
-				Required: true,
+				Optional: true,
+			},
+			"image_config": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"command": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"entrypoint": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"working_directory": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
 			},
 			"function_name": &schema.Schema{
 				Type:     schema.TypeString,
@@ -46,6 +99,46 @@ func resourceFunction() *schema.Resource {
 				Optional: true,
 				Computed: true,
 			},
+			"build": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"language": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringInSlice(buildLanguages, false),
+						},
+						"package": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"dependencies": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"requirements": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "requirements.txt",
+						},
+						"wheelhouse": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"index_url": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
 			"last_updated": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -55,6 +148,14 @@ func resourceFunction() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"role": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"version": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
 			"handler": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
@@ -66,9 +167,28 @@ func resourceFunction() *schema.Resource {
 				Default:  128,
 			},
 			"runtime": &schema.Schema{
-				Type:     schema.TypeString,
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          defaultRuntime,
+				ValidateDiagFunc: validateRuntime,
+			},
+			"layers": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 5,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"architectures": &schema.Schema{
+				Type:     schema.TypeList,
 				Optional: true,
-				Default:  "python3.7",
+				Computed: true,
+				MaxItems: 1,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateArchitecture,
+				},
 			},
 			"timeout": &schema.Schema{
 				Type:     schema.TypeInt,
@@ -80,6 +200,86 @@ func resourceFunction() *schema.Resource {
 				Optional: true,
 				Default:  true,
 			},
+			"tracing": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateTracingMode,
+			},
+			"kms_key_arn": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"secret_variables": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"reference": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"resolve": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+					},
+				},
+			},
+			"log_retention_days": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntInSlice(logRetentionDays),
+			},
+			"log_kms_key_arn": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"log_format": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      lambda.LogFormatText,
+				ValidateFunc: validation.StringInSlice(lambda.LogFormat_Values(), false),
+			},
+			"application_log_level": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(lambda.ApplicationLogLevel_Values(), false),
+			},
+			"system_log_level": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice(lambda.SystemLogLevel_Values(), false),
+			},
+			"log_subscription": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"destination_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"filter_pattern": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "",
+						},
+					},
+				},
+			},
+			"reserved_concurrency": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      -1,
+				ValidateFunc: validation.IntAtLeast(-1),
+			},
 
 			"api_route_trigger": &schema.Schema{
 				Type:     schema.TypeList,
@@ -107,6 +307,18 @@ func resourceFunction() *schema.Resource {
 							Optional: true,
 							Default:  "application/json",
 						},
+						"resource_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"statement_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"target_arn": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
 					},
 				},
 			},
@@ -122,8 +334,83 @@ func resourceFunction() *schema.Resource {
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"cron": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateScheduleExpression,
+						},
+						"backend": &schema.Schema{
 							Type:     schema.TypeString,
-							Required: true,
+							Optional: true,
+							Default:  scheduleBackendRule,
+							ValidateFunc: validation.StringInSlice([]string{
+								scheduleBackendRule, scheduleBackendScheduler,
+							}, false),
+						},
+						"timezone": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateScheduleTimezone,
+						},
+						"start_date": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.IsRFC3339Time,
+						},
+						"end_date": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.IsRFC3339Time,
+						},
+						"flexible_time_window": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							ValidateFunc: validation.IntBetween(0, 1440),
+						},
+						"retry_policy": &schema.Schema{
+							Type:     schema.TypeList,
+							MaxItems: 1,
+							Optional: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"maximum_retry_attempts": &schema.Schema{
+										Type:         schema.TypeInt,
+										Optional:     true,
+										Default:      185,
+										ValidateFunc: validation.IntBetween(0, 185),
+									},
+									"maximum_event_age": &schema.Schema{
+										Type:         schema.TypeInt,
+										Optional:     true,
+										Default:      86400,
+										ValidateFunc: validation.IntBetween(60, 86400),
+									},
+								},
+							},
+						},
+						"input": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringIsJSON,
+						},
+						"enabled": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  true,
+						},
+						// The invocations following the last apply, or the last
+						// change to the schedule outside Terraform. They are not
+						// recomputed on refresh, so some may have passed.
+						"invocations_after_apply_count": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      5,
+							ValidateFunc: validation.IntBetween(0, 100),
+						},
+						"invocations_after_apply": &schema.Schema{
+							Type:     schema.TypeList,
+							Computed: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
 						},
 						"schedule_id": &schema.Schema{
 							Type:     schema.TypeString,
@@ -153,6 +440,60 @@ func resourceFunction() *schema.Resource {
 							Type:     schema.TypeString,
 							Required: true,
 						},
+						"filter_policy": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringIsJSON,
+						},
+						"filter_policy_scope": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "MessageAttributes",
+							ValidateFunc: validation.StringInSlice([]string{
+								"MessageAttributes", "MessageBody",
+							}, false),
+						},
+						"raw_message_delivery": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"batch_size": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      10,
+							ValidateFunc: validation.IntBetween(1, 10000),
+						},
+						"maximum_batching_window": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							ValidateFunc: validation.IntBetween(0, 300),
+						},
+						"report_batch_item_failures": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"max_concurrency": &schema.Schema{
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  0,
+							ValidateFunc: validation.Any(
+								validation.IntInSlice([]int{0}),
+								validation.IntBetween(2, 1000),
+							),
+						},
+						"max_receive_count": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      5,
+							ValidateFunc: validation.IntBetween(1, 1000),
+						},
+						"visibility_timeout": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
 						"subscription_id": &schema.Schema{
 							Type:     schema.TypeString,
 							Computed: true,
@@ -163,6 +504,22 @@ func resourceFunction() *schema.Resource {
 							Computed: true,
 							Optional: true,
 						},
+						"queue_url": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"dead_letter_queue_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"dead_letter_queue_url": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"event_source_mapping_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
 					},
 				},
 			},
@@ -181,6 +538,86 @@ func resourceFunction() *schema.Resource {
 							Type:     schema.TypeString,
 							Required: true,
 						},
+						"events": &schema.Schema{
+							Type:     schema.TypeSet,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+								ValidateFunc: validation.StringInSlice([]string{
+									"created", "removed", "restored",
+								}, false),
+							},
+							Set: schema.HashString,
+						},
+						"prefix": &schema.Schema{
+							Type:          schema.TypeString,
+							Optional:      true,
+							ConflictsWith: []string{"datastore_trigger.0.key_path"},
+						},
+						"suffix": &schema.Schema{
+							Type:          schema.TypeString,
+							Optional:      true,
+							ConflictsWith: []string{"datastore_trigger.0.key_path"},
+						},
+						"key_path": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateKeyPath,
+						},
+						"notification_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"stream_view_type": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  dynamodb.StreamViewTypeNewImage,
+							ValidateFunc: validation.StringInSlice(
+								dynamodb.StreamViewType_Values(), false,
+							),
+						},
+						"starting_position": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  lambda.EventSourcePositionLatest,
+							ValidateFunc: validation.StringInSlice([]string{
+								lambda.EventSourcePositionLatest, lambda.EventSourcePositionTrimHorizon,
+							}, false),
+						},
+						"batch_size": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      100,
+							ValidateFunc: validation.IntBetween(1, 10000),
+						},
+						"bisect_batch_on_error": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+						"on_failure": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"event_types": &schema.Schema{
+							Type:     schema.TypeSet,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+								ValidateFunc: validation.StringInSlice([]string{
+									"INSERT", "MODIFY", "REMOVE",
+								}, false),
+							},
+							Set: schema.HashString,
+						},
+						"stream_arn": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"event_source_mapping_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
 					},
 				},
 			},
@@ -203,292 +640,530 @@ func resourceFunction() *schema.Resource {
 					},
 				},
 			},
-		},
-	}
-}
 
-func resourceFunctionCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	conn := m.(*AWSClient).lambdaconn
-	accountId := m.(*AWSClient).accountid
-	d.Set("account_id", accountId)
+			"outputs": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateOutputName,
+						},
+						"resource_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"queue_url": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"queue_arn": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"receiver_role": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"event_source_mapping_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"receiver_target": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"buffered": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"buffering_size": &schema.Schema{
+										Type:         schema.TypeInt,
+										Optional:     true,
+										Default:      5,
+										ValidateFunc: validation.IntBetween(1, 128),
+									},
+									"buffering_interval": &schema.Schema{
+										Type:         schema.TypeInt,
+										Optional:     true,
+										Default:      300,
+										ValidateFunc: validation.IntBetween(60, 900),
+									},
+									"compression": &schema.Schema{
+										Type:         schema.TypeString,
+										Optional:     true,
+										Default:      firehose.CompressionFormatUncompressed,
+										ValidateFunc: validation.StringInSlice(firehose.CompressionFormat_Values(), false),
+									},
+									"prefix": &schema.Schema{
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"error_output_prefix": &schema.Schema{
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+								},
+							},
+						},
+						"delivery_stream_name": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"delivery_stream_arn": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+			"output_variables": &schema.Schema{
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem:     &schema.Schema{Type: schema.TypeString},
+			},
 
-	var functionName string
-	if v, ok := d.GetOk("function_name"); ok {
-		functionName = v.(string)
-	} else {
-		functionName = resource.UniqueId()
-	}
-	source, _ := d.GetOk("source")
-	zipFilename, err := doTheZip(source.(string))
-	zipFilename = fmt.Sprintf("%s/lambda.zip", source)
+			"deployment": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"alias": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"strategy": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  deploymentAllAtOnce,
+							ValidateFunc: validation.StringInSlice([]string{
+								deploymentAllAtOnce,
+								deploymentCanary,
+								deploymentLinear,
+							}, false),
+						},
+						"percentage": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      10,
+							ValidateFunc: validation.IntBetween(1, 99),
+						},
+						"interval": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      10,
+							ValidateFunc: validation.IntBetween(1, 60),
+						},
+						"alarms": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"alias_arn": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"provisioned_concurrency": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							ValidateFunc: validation.IntAtLeast(0),
+						},
+						"provisioned_concurrency_schedule": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"name": &schema.Schema{
+										Type:     schema.TypeString,
+										Required: true,
+									},
+									"schedule": &schema.Schema{
+										Type:         schema.TypeString,
+										Required:     true,
+										ValidateFunc: validateScheduleExpression,
+									},
+									"timezone": &schema.Schema{
+										Type:         schema.TypeString,
+										Optional:     true,
+										ValidateFunc: validateScheduleTimezone,
+									},
+									"min_capacity": &schema.Schema{
+										Type:         schema.TypeInt,
+										Required:     true,
+										ValidateFunc: validation.IntAtLeast(0),
+									},
+									"max_capacity": &schema.Schema{
+										Type:         schema.TypeInt,
+										Required:     true,
+										ValidateFunc: validation.IntAtLeast(0),
+									},
+								},
+							},
+						},
+						"provisioned_concurrency_status": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"allocated_provisioned_concurrency": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
 
-	var functionCode *lambda.FunctionCode
-	file, err := loadFileContent(zipFilename)
-	if err != nil {
-		return diag.Errorf("Unable to load %q: %s", zipFilename, err)
+			"async": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"maximum_retry_attempts": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      2,
+							ValidateFunc: validation.IntBetween(0, 2),
+						},
+						"maximum_event_age": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      21600,
+							ValidateFunc: validation.IntBetween(60, 21600),
+						},
+						"on_success": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"destination_id": &schema.Schema{
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"queue_url": &schema.Schema{
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+									"queue_arn": &schema.Schema{
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+								},
+							},
+						},
+						"on_failure": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							MaxItems: 1,
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"destination_id": &schema.Schema{
+										Type:     schema.TypeString,
+										Optional: true,
+									},
+									"queue_url": &schema.Schema{
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+									"queue_arn": &schema.Schema{
+										Type:     schema.TypeString,
+										Computed: true,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+
+			"permissions": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"resource_id": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"access": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+							ValidateFunc: validation.StringInSlice([]string{
+								accessRead,
+								accessWrite,
+								accessPublish,
+								accessInvoke,
+							}, false),
+						},
+					},
+				},
+			},
+			"policy_statement": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"effect": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "Allow",
+							ValidateFunc: validation.StringInSlice([]string{"Allow", "Deny"}, false),
+						},
+						"actions": &schema.Schema{
+							Type:     schema.TypeSet,
+							Required: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+						"resources": &schema.Schema{
+							Type:     schema.TypeSet,
+							Required: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func resourceFunctionCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	conn := m.(*AWSClient).lambdaconn
+	accountId := m.(*AWSClient).accountid
+	d.Set("account_id", accountId)
+
+	var functionName string
+	if v, ok := d.GetOk("function_name"); ok {
+		functionName = v.(string)
+	} else {
+		functionName = resource.UniqueId()
+	}
+	d.Set("function_name", functionName)
+	var code *lambda.FunctionCode
+	if isImageFunction(d) {
+		if err := putImagePullPolicy(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+		code = &lambda.FunctionCode{ImageUri: aws.String(d.Get("image_uri").(string))}
+	} else {
+		file, err := functionPackage(d)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		code, err = functionCode(d, m, file)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Queues to other functions and delivery streams must exist before the function does, so that
+	// their URLs can be passed to it
+	outputs := d.Get("outputs").([]interface{})
+	if err := createFunctionOutputQueues(m, outputs, nil); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := createFunctionOutputStreams(d, m, outputs, nil); err != nil {
+		return diag.FromErr(err)
 	}
-	functionCode = &lambda.FunctionCode{
-		ZipFile: file,
+	d.Set("outputs", outputs)
+
+	environment, err := expandFunctionEnvironment(d, m)
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
-	roleName := fmt.Sprintf("arn:aws:iam::%s:role/PlausibleLambdaRole", accountId)
+	roleArn, err := createFunctionRole(m, functionName)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("role", roleArn)
+	if err := putFunctionPermissions(d, m, roleArn); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := putFunctionTracingPolicy(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := putFunctionVpcPolicy(d, m); err != nil {
+		return diag.FromErr(err)
+	}
 	params := &lambda.CreateFunctionInput{
-		Code:         functionCode,
-		FunctionName: aws.String(functionName),
-		Handler:      aws.String(d.Get("handler").(string)),
-		MemorySize:   aws.Int64(int64(d.Get("memory_size").(int))),
-		Runtime:      aws.String(d.Get("runtime").(string)),
-		Timeout:      aws.Int64(int64(d.Get("timeout").(int))),
-		Publish:      aws.Bool(d.Get("publish").(bool)),
-		Role:         aws.String(roleName),
+		Code:          code,
+		FunctionName:  aws.String(functionName),
+		MemorySize:    aws.Int64(int64(d.Get("memory_size").(int))),
+		Timeout:       aws.Int64(int64(d.Get("timeout").(int))),
+		Publish:       aws.Bool(d.Get("publish").(bool)),
+		Role:          aws.String(roleArn),
+		Environment:   environment,
+		LoggingConfig: expandLoggingConfig(d),
+		TracingConfig: expandTracingConfig(d, m),
+	}
+	// Images bring their own runtime and entrypoint
+	if isImageFunction(d) {
+		params.PackageType = aws.String(lambda.PackageTypeImage)
+		params.ImageConfig = expandImageConfig(d)
+	} else {
+		params.Handler = aws.String(d.Get("handler").(string))
+		params.Runtime = aws.String(d.Get("runtime").(string))
+	}
+	if v, ok := d.GetOk("kms_key_arn"); ok {
+		params.KMSKeyArn = aws.String(v.(string))
+	}
+	if _, ok := d.GetOk("vpc"); ok {
+		params.VpcConfig = expandVpcConfig(d)
+	}
+	if v, ok := d.GetOk("layers"); ok {
+		params.Layers = expandStringList(v.([]interface{}))
+	}
+	if v, ok := d.GetOk("architectures"); ok {
+		params.Architectures = []*string{aws.String(v.([]interface{})[0].(string))}
 	}
 
-	lambdaOut, err := conn.CreateFunction(params)
+	if err := createFunctionLogGroup(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+
+	// A new role takes a while to become assumable by Lambda, and its
+	// policies a while to let it create network interfaces
+	var lambdaOut *lambda.FunctionConfiguration
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		var err error
+		lambdaOut, err = conn.CreateFunction(params)
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "cannot be assumed") {
+			return resource.RetryableError(err)
+		}
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "CreateNetworkInterface") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
 	if err != nil {
 		return diag.Errorf("Error creating function: %s", err)
 	}
+	if err := waitForFunctionActive(m, functionName); err != nil {
+		return diag.FromErr(err)
+	}
+
+	if code.S3Key != nil {
+		if err := deleteArtifacts(d, m, functionName, m.(*AWSClient).ArtifactRetention); err != nil {
+			return diag.FromErr(err)
+		}
+	}
 
 	functionArn := lambdaOut.FunctionArn
 	d.SetId(*functionArn)
 	d.Set("arn", *functionArn)
 	d.Set("function_name", functionName)
+	d.Set("role", lambdaOut.Role)
+	d.Set("version", lambdaOut.Version)
 
-	if v, ok := d.GetOk("schedule_trigger"); ok {
-		// A schedule trigger requires a CloudWatch rule that contains the schedule,
-		// a Lambda permission that allows this rule to invoke the Lambda, and
-		// an Event Target that tells the rule to invoke the Lambda
-		triggerInfo := v.([]*schema.ResourceData)[0]
+	// Parameter names are resolved against the function's ARN, so access to
+	// secrets looked up at runtime is granted once the function exists
+	if err := putFunctionSecrets(d, m); err != nil {
+		return diag.FromErr(err)
+	}
 
-		// Create CloudWatch event rule
-		cwconn := m.(*AWSClient).cloudwatcheventsconn
-		ruleName := resource.UniqueId()
-		ruleInput := events.PutRuleInput{
-			Name: aws.String(ruleName),
+	if d.Get("reserved_concurrency").(int) >= 0 {
+		if err := putFunctionReservedConcurrency(d, m); err != nil {
+			return diag.FromErr(err)
 		}
-		if c, ok := triggerInfo.GetOk("cron"); ok {
-			ruleInput.ScheduleExpression = aws.String(c.(string))
-		}
-
-		ruleInput.State = aws.String("true")
-		ruleOut, err := cwconn.PutRule(&ruleInput)
-		d.Set("schedule_trigger_enabled", true)
-		triggerInfo.Set("schedule_id", ruleOut.RuleArn)
-		triggerInfo.Set("schedule_name", ruleName)
+	}
 
-		// Create Lambda permission
-		input := lambda.AddPermissionInput{
-			Action:       aws.String("lambda:InvokeFunction"),
-			FunctionName: aws.String(functionName),
-			Principal:    aws.String("events.amazonaws.com"),
-			StatementId:  aws.String(resource.UniqueId()),
-			SourceArn:    aws.String(d.Get("schedule_arn").(string)),
+	// Triggers invoke the alias, so it has to exist before they do
+	if _, ok := d.GetOk("deployment"); ok {
+		if err := deployFunction(d, m, aws.StringValue(lambdaOut.Version)); err != nil {
+			return diag.FromErr(err)
+		}
+		if err := putFunctionProvisionedConcurrency(d, m); err != nil {
+			return diag.FromErr(err)
 		}
+	}
 
-		_, err = conn.AddPermission(&input)
-		if err != nil {
-			return diag.Errorf("Error adding lambda permission %+v", err)
+	if v, ok := d.GetOk("log_subscription"); ok {
+		if err := putLogSubscription(d, m, v.([]interface{})[0].(map[string]interface{})); err != nil {
+			return diag.FromErr(err)
 		}
+	}
 
-		// Create Cloudwatch event target
-		targetInput := &events.PutTargetsInput{
-			Rule: aws.String(ruleName),
-			Targets: []*events.Target{
-				&events.Target{
-					Arn: aws.String(d.Id()),
-				},
-			},
+	if v, ok := d.GetOk("async"); ok {
+		async := v.([]interface{})[0].(map[string]interface{})
+		if err := putFunctionAsync(d, m, nil, async); err != nil {
+			return diag.FromErr(err)
 		}
-		_, err = cwconn.PutTargets(targetInput)
-		if err != nil {
-			return diag.Errorf("Creating CloudWatch Event Target failed: %s", err)
+		d.Set("async", []interface{}{async})
+	}
+
+	// Grant the function access to its outputs
+	if err := putFunctionOutputs(d, m, aws.StringValue(lambdaOut.Role)); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := connectFunctionOutputQueues(d, m, outputs); err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("outputs", outputs)
+
+	if v, ok := d.GetOk("schedule_trigger"); ok {
+		triggerInfo := v.([]interface{})[0].(map[string]interface{})
+		if err := createScheduleTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
 		}
+		d.Set("schedule_trigger", []interface{}{triggerInfo})
+		d.Set("schedule_trigger_enabled", true)
 	} else {
 		d.Set("schedule_trigger_enabled", false)
 	}
 
 	if v, ok := d.GetOk("api_route_trigger"); ok {
-		// An API Route trigger requires a Lambda permission, and an integration on an
-		// existing API method to trigger the Lambda
-		apiconn := m.(*AWSClient).apigatewayconn
-		triggerInfo := v.([]*schema.ResourceData)[0]
-		var api_id = triggerInfo.Get("api_id").(string)
-		var method = triggerInfo.Get("method").(string)
-		var route = triggerInfo.Get("route").(string)
-		region := m.(*AWSClient).region
-
-		// Create Lambda permission
-		sourceArn := fmt.Sprintf("arn:aws:execute-api:%s:%s:%s/*", region, accountId, api_id)
-		input := lambda.AddPermissionInput{
-			Action:       aws.String("lambda:InvokeFunction"),
-			FunctionName: aws.String(functionName),
-			Principal:    aws.String("apigateway.amazonaws.com"),
-			StatementId:  aws.String(resource.UniqueId()),
-			SourceArn:    aws.String(sourceArn),
-		}
-
-		_, err = conn.AddPermission(&input)
-		if err != nil {
-			return diag.Errorf("Error adding lambda permission %+v", err)
-		}
-
-		// Search list of resources to find the correct one (by path)
-		r, err := apiconn.GetResources(&apigateway.GetResourcesInput{
-			Limit:     aws.Int64(int64(500)),
-			RestApiId: aws.String(api_id),
-		})
-		if err != nil {
-			return diag.Errorf("Error getting resources for api %+v", err)
-		}
-		var resourceId *string
-		for _, r := range r.Items {
-			// rsc := r.(*apigateway.Resource)
-			if *r.Path == route {
-				resourceId = r.Id
-				break
-			}
-		}
-		if resourceId == nil {
-			return diag.Errorf("No resource found in api for route %s", route)
-		}
-
-		// Create API method integration
-		// https://docs.aws.amazon.com/apigateway/latest/developerguide/set-up-lambda-custom-integrations.html
-		// https://docs.aws.amazon.com/apigateway/api-reference/link-relation/integration-put/
-		uri := fmt.Sprintf("arn:aws:apigateway:%s:lambda:path/2015-03-31/functions/arn:aws:lambda:%s:%s:function:%s/invocations", region, region, accountId, functionName, route)
-		_, err = apiconn.PutIntegration(&apigateway.PutIntegrationInput{
-			HttpMethod:            aws.String(method),
-			ResourceId:            aws.String(*resourceId),
-			RestApiId:             aws.String(api_id),
-			Type:                  aws.String("AWS"),
-			IntegrationHttpMethod: aws.String("POST"),
-			Uri:                   aws.String(uri),
-			Credentials:           aws.String(fmt.Sprintf("arn:aws:iam::%s:role/PlausibleLambdaRole", accountId)),
-		})
-		if err != nil {
-			return diag.Errorf("Error creating API Gateway Integration: %+v", err)
+		triggerInfo := v.([]interface{})[0].(map[string]interface{})
+		if err := createApiRouteTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
 		}
+		d.Set("api_route_trigger", []interface{}{triggerInfo})
 		d.Set("api_route_trigger_enabled", true)
+	} else {
+		d.Set("api_route_trigger_enabled", false)
 	}
 
 	if v, ok := d.GetOk("subscription_trigger"); ok {
-		// To trigger a Lambda from a subscription, we create an SQS queue to subscribe to the
-		// existing SNS topic, and trigger the Lambda from that queue. This provides shock
-		// absorption and prevents message loss in the case of throughput that exceeds
-		// concurrency limits
-		triggerInfo := v.([]*schema.ResourceData)[0]
-		sqsconn := m.(*AWSClient).sqsconn
-
-		// Create the SQS queue and retrieve its Arn
-		var queueOutput, err = sqsconn.CreateQueue(&sqs.CreateQueueInput{
-			QueueName: aws.String(resource.UniqueId()),
-		})
-		if err != nil {
-			return diag.Errorf("Creating SQS queue failed: %s", err)
+		triggerInfo := v.([]interface{})[0].(map[string]interface{})
+		if err := createSubscriptionTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
 		}
-
-		attNames := []*string{aws.String("QueueArn")}
-		queueAttributes, err := sqsconn.GetQueueAttributes(&sqs.GetQueueAttributesInput{
-			QueueUrl:       queueOutput.QueueUrl,
-			AttributeNames: attNames,
-		})
-		if err != nil {
-			return diag.Errorf("Getting queue attributes failed: %s", err)
-		}
-
-		// Create topic subscription for SQS queue
-		snsconn := m.(*AWSClient).snsconn
-
-		req := &sns.SubscribeInput{
-			Protocol: aws.String("sqs"),
-			Endpoint: queueAttributes.Attributes["QueueArn"],
-			TopicArn: aws.String(triggerInfo.Get("publisher_id").(string)),
-		}
-		output, err := snsconn.Subscribe(req)
-		if err != nil {
-			return diag.Errorf("Creating SNS subscription failed: %s", err)
-		}
-
-		// Create lambda event source mapping
-		params := &lambda.CreateEventSourceMappingInput{
-			EventSourceArn: queueAttributes.Attributes["QueueArn"],
-			FunctionName:   aws.String(functionName),
-			Enabled:        aws.Bool(true),
-		}
-		_, err = conn.CreateEventSourceMapping(params)
-		if err != nil {
-			return diag.Errorf("Creating Lambda event source mapping: %s", err)
-		}
-
-		triggerInfo.Set("queue_id", queueAttributes.Attributes["QueueArn"])
-		triggerInfo.Set("subscription_id", output.SubscriptionArn)
-
+		d.Set("subscription_trigger", []interface{}{triggerInfo})
 		d.Set("subscription_trigger_enabled", true)
 	} else {
 		d.Set("subscription_trigger_enabled", false)
 	}
 
 	if v, ok := d.GetOk("datastore_trigger"); ok {
-		// Resource creation depends on the type of datastore - key/value, object
-		datastoreId := v.([]*schema.ResourceData)[0].Get("publisher_id").(string)
-		datastoreArn, err := arn.Parse(datastoreId)
-		if strings.Contains(strings.ToLower(datastoreId), ":dynamodb") {
-			// Enable dynamodb stream
-			ddbconn := m.(*AWSClient).dynamodbconn
-			tableName := strings.Split(datastoreArn.Resource, "/")[1]
-			input := &dynamodb.UpdateTableInput{
-				TableName: aws.String(tableName),
-				StreamSpecification: &dynamodb.StreamSpecification{
-					StreamEnabled:  aws.Bool(true),
-					StreamViewType: aws.String(dynamodb.StreamViewTypeNewImage),
-				},
-			}
-			updateOutput, _ := ddbconn.UpdateTable(input)
-
-			// Create Lambda event source mapping
-			params := &lambda.CreateEventSourceMappingInput{
-				EventSourceArn: updateOutput.TableDescription.LatestStreamArn,
-				FunctionName:   aws.String(functionName),
-				Enabled:        aws.Bool(true),
-			}
-			// eventSourceMappingConfiguration, err := conn.CreateEventSourceMapping(params)
-			_, _ = conn.CreateEventSourceMapping(params)
-		} else if strings.Contains(strings.ToLower(datastoreId), ":s3") {
-			// Configure S3 object-level events
-			// s3conn := m.(*AWSClient).s3conn
-
-			// Create Lambda permission
-			input := lambda.AddPermissionInput{
-				Action:       aws.String("lambda:InvokeFunction"),
-				FunctionName: aws.String(functionName),
-				Principal:    aws.String("apigateway.amazonaws.com"),
-				StatementId:  aws.String(resource.UniqueId()),
-				SourceArn:    aws.String("arn:aws:execute-api:*:*:*"),
-			}
-
-			_, err = conn.AddPermission(&input)
-			if err != nil {
-				return diag.Errorf("Error adding lambda permission %s", err)
-			}
-
-			notificationConfiguration := &s3.NotificationConfiguration{}
-			lc := &s3.LambdaFunctionConfiguration{}
-			lc.Id = aws.String(resource.UniqueId())
-			lc.LambdaFunctionArn = aws.String(*lambdaOut.FunctionArn)
-			lc.Events = []*string{aws.String("s3:ObjectCreated:*")}
-			notificationConfiguration.LambdaFunctionConfigurations = []*s3.LambdaFunctionConfiguration{lc}
-			var bucket = "my ucket"
-			_ = &s3.PutBucketNotificationConfigurationInput{
-				Bucket:                    aws.String(bucket),
-				NotificationConfiguration: notificationConfiguration,
-			}
+		triggerInfo := v.([]interface{})[0].(map[string]interface{})
+		if err := createDatastoreTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
 		}
+		d.Set("datastore_trigger", []interface{}{triggerInfo})
 		d.Set("datastore_trigger_enabled", true)
 	} else {
 		d.Set("datastore_trigger_enabled", false)
 	}
 
-	registryPut(m.(*AWSClient).appname)
-	return resourceFunctionRead(ctx, d, m)
+	if err := registryPutFunction(m.(*AWSClient).AppName, d); err != nil {
+		return diag.Errorf("Error registering function %s: %s", d.Id(), err)
+	}
+
+	// Defaults are not validated, so functions left on the default runtime
+	// are warned about here
+	var diags diag.Diagnostics
+	if runtime := d.Get("runtime").(string); runtime == defaultRuntime && !isImageFunction(d) {
+		diags = validateRuntime(runtime, cty.GetAttrPath("runtime"))
+	}
+	return append(diags, resourceFunctionRead(ctx, d, m)...)
 
 }
 
@@ -506,79 +1181,516 @@ func resourceFunctionRead(ctx context.Context, d *schema.ResourceData, m interfa
 	function := getFunctionOutput.Configuration
 	d.Set("arn", function.FunctionArn)
 	d.Set("function_name", function.FunctionName)
-	d.Set("handler", function.Handler)
 	d.Set("memory_size", function.MemorySize)
 	d.Set("last_modified", function.LastModified)
 	d.Set("role", function.Role)
-	d.Set("runtime", function.Runtime)
+	// Image functions have no handler or runtime, which keep their defaults
+	if aws.StringValue(function.PackageType) == lambda.PackageTypeImage {
+		d.Set("image_uri", getFunctionOutput.Code.ImageUri)
+		d.Set("image_config", flattenImageConfig(function.ImageConfigResponse))
+	} else {
+		d.Set("handler", function.Handler)
+		d.Set("runtime", function.Runtime)
+	}
+	d.Set("architectures", aws.StringValueSlice(function.Architectures))
+	layers := []string{}
+	for _, layer := range function.Layers {
+		layers = append(layers, aws.StringValue(layer.Arn))
+	}
+	d.Set("layers", layers)
+	d.Set("vpc", flattenVpcConfig(function.VpcConfig))
 	d.Set("timeout", function.Timeout)
-	// d.Set("kms_key_arn", function.KMSKeyArn)
+	d.Set("kms_key_arn", function.KMSKeyArn)
 	d.Set("source_code_hash", function.CodeSha256)
 	d.Set("source_code_size", function.CodeSize)
 
 	// invokeArn := lambdaFunctionInvokeArn(*function.FunctionArn, meta)
 	// d.Set("invoke_arn", invokeArn)
 
-	return diags
-}
+	outputVariables, err := functionOutputVariables(d.Get("outputs").([]interface{}))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("output_variables", outputVariables)
 
-func resourceFunctionUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
+	if err := readFunctionReservedConcurrency(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+
+	if _, ok := d.GetOk("deployment"); ok {
+		if err := readFunctionDeployment(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+		if err := readFunctionProvisionedConcurrency(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if v := d.Get("schedule_trigger_enabled").(bool); v {
+		triggerInfo := d.Get("schedule_trigger").([]interface{})[0].(map[string]interface{})
+		if err := readScheduleTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
+		}
+		d.Set("schedule_trigger", []interface{}{triggerInfo})
+	}
 
 	return diags
 }
 
-func resourceFunctionDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
+func resourceFunctionUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	conn := m.(*AWSClient).lambdaconn
-	cwconn := m.(*AWSClient).cloudwatcheventsconn
 
-	if v := d.Get("schedule_trigger_enabled").(bool); v {
-		// Delete CloudWatch event target
-		targetInput := &events.RemoveTargetsInput{
-			Ids:  []*string{aws.String(d.Get("schedule_target_id").(string))},
-			Rule: aws.String(d.Get("schedule_name").(string)),
+	// Nothing is granted on the role shared by older functions
+	if err := moveToFunctionRole(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+
+	o, n := d.GetChange("outputs")
+	oldOutputs, newOutputs := o.([]interface{}), n.([]interface{})
+	if d.HasChange("outputs") {
+		if err := createFunctionOutputQueues(m, newOutputs, oldOutputs); err != nil {
+			return diag.FromErr(err)
 		}
-		_, err := cwconn.RemoveTargets(targetInput)
-		if err != nil {
-			return diag.Errorf("Error removing scheduling target: %s", err)
+		if err := createFunctionOutputStreams(d, m, newOutputs, oldOutputs); err != nil {
+			return diag.FromErr(err)
+		}
+		d.Set("outputs", newOutputs)
+	}
+
+	if d.HasChanges("log_retention_days", "log_kms_key_arn") {
+		if err := updateFunctionLogGroup(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("tracing") {
+		if err := putFunctionTracingPolicy(d, m); err != nil {
+			return diag.FromErr(err)
 		}
+	}
 
-		// Delete Lambda permission
-		permInput := lambda.RemovePermissionInput{
-			FunctionName: aws.String(d.Get("function_name").(string)),
-			StatementId:  aws.String(d.Id()),
+	if d.HasChange("image_uri") {
+		if err := putImagePullPolicy(d, m); err != nil {
+			return diag.FromErr(err)
 		}
-		_, err = conn.RemovePermission(&permInput)
+	}
+
+	if d.HasChanges("source_code_hash", "image_uri", "architectures") {
+		if err := updateFunctionCode(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChanges("secret_variables", "kms_key_arn") {
+		if err := putFunctionSecrets(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Access to network interfaces is granted before the function joins a VPC,
+	// and revoked after it has left
+	_, inVpc := d.GetOk("vpc")
+	if d.HasChange("vpc") && inVpc {
+		if err := putFunctionVpcPolicy(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Resolved secrets are looked up again whenever the configuration changes
+	if d.HasChanges("handler", "memory_size", "timeout", "runtime", "image_config", "layers", "vpc", "environment", "outputs", "secret_variables", "kms_key_arn", "log_format", "application_log_level", "system_log_level", "tracing") {
+		environment, err := expandFunctionEnvironment(d, m)
 		if err != nil {
-			return diag.Errorf("Error removing Lambda permission: %s", err)
+			return diag.FromErr(err)
+		}
+		input := &lambda.UpdateFunctionConfigurationInput{
+			FunctionName:  aws.String(d.Get("function_name").(string)),
+			MemorySize:    aws.Int64(int64(d.Get("memory_size").(int))),
+			Timeout:       aws.Int64(int64(d.Get("timeout").(int))),
+			Environment:   environment,
+			LoggingConfig: expandLoggingConfig(d),
+			TracingConfig: expandTracingConfig(d, m),
+			KMSKeyArn:     aws.String(d.Get("kms_key_arn").(string)),
+			VpcConfig:     expandVpcConfig(d),
+		}
+		if isImageFunction(d) {
+			input.ImageConfig = expandImageConfig(d)
+		} else {
+			input.Handler = aws.String(d.Get("handler").(string))
+			input.Runtime = aws.String(d.Get("runtime").(string))
+			input.Layers = expandStringList(d.Get("layers").([]interface{}))
+		}
+		_, err = conn.UpdateFunctionConfiguration(input)
+		if err != nil {
+			return diag.Errorf("Error updating function configuration: %s", err)
+		}
+		if err := waitForFunctionUpdated(m, d.Get("function_name").(string)); err != nil {
+			return diag.FromErr(err)
 		}
+	}
+
+	if d.HasChange("vpc") && !inVpc {
+		if err := putFunctionVpcPolicy(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
 
-		// Delete CloudWatch event rule
-		ruleInput := &events.DeleteRuleInput{
-			Name: aws.String(d.Id()),
+	if d.HasChange("outputs") {
+		if err := putFunctionOutputs(d, m, d.Get("role").(string)); err != nil {
+			return diag.FromErr(err)
+		}
+		if err := connectFunctionOutputQueues(d, m, newOutputs); err != nil {
+			return diag.FromErr(err)
+		}
+		d.Set("outputs", newOutputs)
+		if err := deleteFunctionOutputQueues(m, newOutputs, oldOutputs); err != nil {
+			return diag.FromErr(err)
 		}
-		_, err = cwconn.DeleteRule(ruleInput)
+		if err := deleteFunctionOutputStreams(d, m, newOutputs, oldOutputs); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Publish whatever changed above and roll it out through the alias
+	if _, ok := d.GetOk("deployment"); ok {
+		version, err := publishFunctionVersion(d, m)
 		if err != nil {
-			return diag.Errorf("Error removing scheduling rule: %s", err)
+			return diag.FromErr(err)
+		}
+		previous := d.Get("version").(string)
+		if err := deployFunction(d, m, version); err != nil {
+			return diag.FromErr(err)
+		}
+		// A new version has its provisioned concurrency allocated afresh
+		if version != previous || d.HasChange("deployment") {
+			if err := putFunctionProvisionedConcurrency(d, m); err != nil {
+				return diag.FromErr(err)
+			}
+		}
+	}
+	if d.HasChange("deployment") {
+		if err := registryPutFunction(m.(*AWSClient).AppName, d); err != nil {
+			return diag.Errorf("Error registering function %s: %s", d.Id(), err)
 		}
 	}
 
-	if _, ok := d.GetOk("subscription_trigger_enabled"); ok {
-		// Delete topic subscription
+	if d.HasChange("log_subscription") {
+		o, n := d.GetChange("log_subscription")
+		oldSubscriptions, newSubscriptions := o.([]interface{}), n.([]interface{})
+
+		// The filter is replaced in place, but the old destination's access
+		// is only revoked if the destination changed
+		if len(oldSubscriptions) > 0 {
+			oldSubscription := oldSubscriptions[0].(map[string]interface{})
+			if len(newSubscriptions) == 0 || newSubscriptions[0].(map[string]interface{})["destination_id"] != oldSubscription["destination_id"] {
+				if err := deleteLogSubscription(d, m, oldSubscription); err != nil {
+					return diag.FromErr(err)
+				}
+			}
+		}
+		if len(newSubscriptions) > 0 {
+			if err := putLogSubscription(d, m, newSubscriptions[0].(map[string]interface{})); err != nil {
+				return diag.FromErr(err)
+			}
+		}
 	}
 
-	if _, ok := d.GetOk("datastore_trigger_enabled"); ok {
-		// delete
+	if d.HasChange("async") {
+		o, n := d.GetChange("async")
+		oldAsyncs, newAsyncs := o.([]interface{}), n.([]interface{})
+
+		switch {
+		case len(oldAsyncs) > 0 && len(newAsyncs) == 0:
+			if err := deleteFunctionAsync(d, m, oldAsyncs[0].(map[string]interface{})); err != nil {
+				return diag.FromErr(err)
+			}
+		case len(newAsyncs) > 0:
+			var oldAsync map[string]interface{}
+			if len(oldAsyncs) > 0 {
+				oldAsync = oldAsyncs[0].(map[string]interface{})
+			}
+			async := newAsyncs[0].(map[string]interface{})
+			if err := putFunctionAsync(d, m, oldAsync, async); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("async", []interface{}{async})
+		}
+	}
+
+	if d.HasChange("reserved_concurrency") {
+		if err := putFunctionReservedConcurrency(d, m); err != nil {
+			return diag.FromErr(err)
+		}
 	}
 
-	if _, ok := d.GetOk("api_route_trigger_enabled"); ok {
+	if d.HasChanges("permissions", "policy_statement") {
+		if err := putFunctionPermissions(d, m, d.Get("role").(string)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if d.HasChange("schedule_trigger") {
+		o, n := d.GetChange("schedule_trigger")
+		oldTriggers, newTriggers := o.([]interface{}), n.([]interface{})
+
+		switch {
+		case len(oldTriggers) > 0 && len(newTriggers) == 0:
+			if err := deleteScheduleTrigger(d, m, oldTriggers[0].(map[string]interface{})); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("schedule_trigger_enabled", false)
+		case len(oldTriggers) == 0 && len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := createScheduleTrigger(d, m, triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("schedule_trigger", []interface{}{triggerInfo})
+			d.Set("schedule_trigger_enabled", true)
+		case len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := updateScheduleTrigger(d, m, oldTriggers[0].(map[string]interface{}), triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("schedule_trigger", []interface{}{triggerInfo})
+		}
+	}
+
+	if d.HasChanges("subscription_trigger", "timeout") {
+		o, n := d.GetChange("subscription_trigger")
+		oldTriggers, newTriggers := o.([]interface{}), n.([]interface{})
+
+		switch {
+		case len(oldTriggers) > 0 && len(newTriggers) == 0:
+			if err := deleteSubscriptionTrigger(d, m, oldTriggers[0].(map[string]interface{})); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("subscription_trigger_enabled", false)
+		case len(oldTriggers) == 0 && len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := createSubscriptionTrigger(d, m, triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("subscription_trigger", []interface{}{triggerInfo})
+			d.Set("subscription_trigger_enabled", true)
+		case len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := updateSubscriptionTrigger(d, m, oldTriggers[0].(map[string]interface{}), triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("subscription_trigger", []interface{}{triggerInfo})
+		}
+	}
+
+	// The integration follows the alias, which the deployment above may have
+	// changed
+	if d.HasChange("api_route_trigger") || apiRouteTargetChanged(d) {
+		o, n := d.GetChange("api_route_trigger")
+		oldTriggers, newTriggers := o.([]interface{}), n.([]interface{})
+
+		switch {
+		case len(oldTriggers) > 0 && len(newTriggers) == 0:
+			if err := deleteApiRouteTrigger(d, m, oldTriggers[0].(map[string]interface{})); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("api_route_trigger_enabled", false)
+		case len(oldTriggers) == 0 && len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := createApiRouteTrigger(d, m, triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("api_route_trigger", []interface{}{triggerInfo})
+			d.Set("api_route_trigger_enabled", true)
+		case len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := updateApiRouteTrigger(d, m, oldTriggers[0].(map[string]interface{}), triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("api_route_trigger", []interface{}{triggerInfo})
+		}
+	}
+
+	if d.HasChange("datastore_trigger") {
+		o, n := d.GetChange("datastore_trigger")
+		oldTriggers, newTriggers := o.([]interface{}), n.([]interface{})
+
+		switch {
+		case len(oldTriggers) > 0 && len(newTriggers) == 0:
+			if err := deleteDatastoreTrigger(d, m, oldTriggers[0].(map[string]interface{})); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("datastore_trigger_enabled", false)
+		case len(oldTriggers) == 0 && len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := createDatastoreTrigger(d, m, triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("datastore_trigger", []interface{}{triggerInfo})
+			d.Set("datastore_trigger_enabled", true)
+		case len(newTriggers) > 0:
+			triggerInfo := newTriggers[0].(map[string]interface{})
+			if err := updateDatastoreTrigger(d, m, oldTriggers[0].(map[string]interface{}), triggerInfo); err != nil {
+				return diag.FromErr(err)
+			}
+			d.Set("datastore_trigger", []interface{}{triggerInfo})
+		}
+	}
+
+	return resourceFunctionRead(ctx, d, m)
+}
+
+func resourceFunctionDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+
+	if v := d.Get("schedule_trigger_enabled").(bool); v {
+		triggerInfo := d.Get("schedule_trigger").([]interface{})[0].(map[string]interface{})
+		if err := deleteScheduleTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if v := d.Get("subscription_trigger_enabled").(bool); v {
+		triggerInfo := d.Get("subscription_trigger").([]interface{})[0].(map[string]interface{})
+		if err := deleteSubscriptionTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if v := d.Get("datastore_trigger_enabled").(bool); v {
+		triggerInfo := d.Get("datastore_trigger").([]interface{})[0].(map[string]interface{})
+		if err := deleteDatastoreTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if v := d.Get("api_route_trigger_enabled").(bool); v {
+		triggerInfo := d.Get("api_route_trigger").([]interface{})[0].(map[string]interface{})
+		if err := deleteApiRouteTrigger(d, m, triggerInfo); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if err := deleteFunctionOutputQueues(m, nil, d.Get("outputs").([]interface{})); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := deleteFunctionOutputStreams(d, m, nil, d.Get("outputs").([]interface{})); err != nil {
+		return diag.FromErr(err)
+	}
+
+	if v, ok := d.GetOk("log_subscription"); ok {
+		if err := deleteLogSubscription(d, m, v.([]interface{})[0].(map[string]interface{})); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	if v, ok := d.GetOk("async"); ok {
+		if err := deleteFunctionAsync(d, m, v.([]interface{})[0].(map[string]interface{})); err != nil {
+			return diag.FromErr(err)
+		}
 	}
 
 	// Delete the lambda function
+	functionName := d.Get("function_name").(string)
+	if v, ok := d.GetOk("deployment"); ok {
+		deployment := v.([]interface{})[0].(map[string]interface{})
+		if err := deleteProvisionedConcurrencySchedule(d, m, deployment["alias"].(string)); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	_, err := m.(*AWSClient).lambdaconn.DeleteFunction(&lambda.DeleteFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		return diag.Errorf("Error deleting function %s: %s", functionName, err)
+	}
+	if err := deleteArtifacts(d, m, functionName, 0); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := deleteFunctionLogGroup(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+	if err := deleteFunctionEnis(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+
+	// The logs policy is on the shared PlausibleLogsRole whatever role the
+	// function runs as
+	if err := deleteRoleInlinePolicy(m.(*AWSClient).iamconn, logsRoleArn(d, m), logsPolicyName(functionName)); err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Functions created before they had roles of their own run as the shared
+	// PlausibleLambdaRole, which only loses this function's policies
+	roleArn := d.Get("role").(string)
+	if hasFunctionRole(d) {
+		if err := deleteFunctionRole(m, roleArn); err != nil {
+			return diag.FromErr(err)
+		}
+		return diags
+	}
+	for _, policyName := range functionPolicyNames(functionName) {
+		if err := deleteRoleInlinePolicy(m.(*AWSClient).iamconn, roleArn, policyName); err != nil {
+			return diag.FromErr(err)
+		}
+	}
 	return diags
 }
 
+func resourceFunctionCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, m interface{}) error {
+	if err := validateScheduleTrigger(diff); err != nil {
+		return err
+	}
+	if err := validateSubscriptionTrigger(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionOutputs(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionDeployment(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionConcurrency(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionAsync(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionLogs(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionSecrets(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionArchitecture(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionImage(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionBuild(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionHandler(diff); err != nil {
+		return err
+	}
+	if err := validateFunctionDependencies(diff); err != nil {
+		return err
+	}
+	if err := planFunctionPackage(diff); err != nil {
+		return err
+	}
+	if err := planFunctionImage(diff, m); err != nil {
+		return err
+	}
+	return validateFunctionPermissions(diff)
+}
+
+func expandStringList(list []interface{}) []*string {
+	values := []*string{}
+	for _, v := range list {
+		values = append(values, aws.String(v.(string)))
+	}
+	return values
+}
+
 func loadFileContent(v string) ([]byte, error) {
 	filename, err := homedir.Expand(v)
 	if err != nil {
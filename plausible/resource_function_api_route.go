@@ -0,0 +1,130 @@
+package plausible
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// An API route trigger integrates an existing method of an API with the
+// function's deployment alias, and adds a Lambda permission that lets the API
+// invoke it. The trigger remembers the ARN it integrated with, so that moving
+// the alias moves the integration and the permission with it.
+
+// createApiRouteTrigger integrates the route's method with the function
+func createApiRouteTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	apiconn := m.(*AWSClient).apigatewayconn
+	partition, region, accountId := m.(*AWSClient).partition, m.(*AWSClient).region, m.(*AWSClient).accountid
+	apiId := triggerInfo["api_id"].(string)
+	route := triggerInfo["route"].(string)
+	targetArn := functionTargetArn(d)
+
+	resourceId, err := apiRouteResourceId(m, apiId, route)
+	if err != nil {
+		return err
+	}
+	triggerInfo["resource_id"] = resourceId
+
+	// Create Lambda permission on the alias that the integration invokes
+	statementId := resource.UniqueId()
+	_, err = conn.AddPermission(&lambda.AddPermissionInput{
+		Action:       aws.String("lambda:InvokeFunction"),
+		FunctionName: aws.String(targetArn),
+		Principal:    aws.String("apigateway.amazonaws.com"),
+		StatementId:  aws.String(statementId),
+		SourceArn:    aws.String(fmt.Sprintf("arn:%s:execute-api:%s:%s:%s/*", partition, region, accountId, apiId)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error adding lambda permission for API route %s: %s", route, err)
+	}
+	triggerInfo["statement_id"] = statementId
+	triggerInfo["target_arn"] = targetArn
+
+	// Create API method integration
+	// https://docs.aws.amazon.com/apigateway/latest/developerguide/set-up-lambda-custom-integrations.html
+	// https://docs.aws.amazon.com/apigateway/api-reference/link-relation/integration-put/
+	_, err = apiconn.PutIntegration(&apigateway.PutIntegrationInput{
+		HttpMethod:            aws.String(strings.ToUpper(triggerInfo["method"].(string))),
+		ResourceId:            aws.String(resourceId),
+		RestApiId:             aws.String(apiId),
+		Type:                  aws.String(apigateway.IntegrationTypeAws),
+		IntegrationHttpMethod: aws.String("POST"),
+		Uri:                   aws.String(fmt.Sprintf("arn:%s:apigateway:%s:lambda:path/2015-03-31/functions/%s/invocations", partition, region, targetArn)),
+		Credentials:           aws.String(fmt.Sprintf("arn:%s:iam::%s:role/PlausibleLambdaRole", partition, accountId)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error creating API Gateway Integration for route %s: %s", route, err)
+	}
+	return nil
+}
+
+// updateApiRouteTrigger replaces the integration and permission, since both
+// name the ARN they invoke
+func updateApiRouteTrigger(d *schema.ResourceData, m interface{}, oldInfo, triggerInfo map[string]interface{}) error {
+	if err := deleteApiRouteTrigger(d, m, oldInfo); err != nil {
+		return err
+	}
+	return createApiRouteTrigger(d, m, triggerInfo)
+}
+
+// deleteApiRouteTrigger removes the integration and the permission
+func deleteApiRouteTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	if resourceId := triggerInfo["resource_id"].(string); resourceId != "" {
+		_, err := m.(*AWSClient).apigatewayconn.DeleteIntegration(&apigateway.DeleteIntegrationInput{
+			HttpMethod: aws.String(strings.ToUpper(triggerInfo["method"].(string))),
+			ResourceId: aws.String(resourceId),
+			RestApiId:  aws.String(triggerInfo["api_id"].(string)),
+		})
+		if err != nil && !isAWSErr(err, apigateway.ErrCodeNotFoundException, "") {
+			return fmt.Errorf("Error removing API Gateway Integration for route %s: %s", triggerInfo["route"], err)
+		}
+	}
+
+	if statementId := triggerInfo["statement_id"].(string); statementId != "" {
+		_, err := m.(*AWSClient).lambdaconn.RemovePermission(&lambda.RemovePermissionInput{
+			FunctionName: aws.String(triggerInfo["target_arn"].(string)),
+			StatementId:  aws.String(statementId),
+		})
+		if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+			return fmt.Errorf("Error removing lambda permission for API route %s: %s", triggerInfo["route"], err)
+		}
+	}
+	return nil
+}
+
+// apiRouteTargetChanged reports whether the integration invokes something
+// other than the function's current target
+func apiRouteTargetChanged(d *schema.ResourceData) bool {
+	triggers := d.Get("api_route_trigger").([]interface{})
+	return len(triggers) > 0 && triggers[0].(map[string]interface{})["target_arn"].(string) != functionTargetArn(d)
+}
+
+// apiRouteResourceId finds the API resource with the route's path
+func apiRouteResourceId(m interface{}, apiId string, route string) (string, error) {
+	var resourceId string
+	err := m.(*AWSClient).apigatewayconn.GetResourcesPages(&apigateway.GetResourcesInput{
+		Limit:     aws.Int64(500),
+		RestApiId: aws.String(apiId),
+	}, func(page *apigateway.GetResourcesOutput, lastPage bool) bool {
+		for _, r := range page.Items {
+			if aws.StringValue(r.Path) == route {
+				resourceId = aws.StringValue(r.Id)
+				return false
+			}
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error getting resources of API %s: %s", apiId, err)
+	}
+	if resourceId == "" {
+		return "", fmt.Errorf("No resource found in API %s for route %s", apiId, route)
+	}
+	return resourceId, nil
+}
@@ -0,0 +1,218 @@
+package plausible
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// The async block configures how Lambda retries asynchronous invocations, as
+// made by schedule and object store triggers, and where it sends the records
+// of those that succeed or fail. A destination is another function, a
+// publisher, or, when no destination_id is given, a queue generated for it.
+// The function's role gets an inline policy to reach its destinations.
+
+var asyncDestinations = []string{"on_success", "on_failure"}
+
+func destinationsPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-destinations", functionName)
+}
+
+// asyncDestinationArn returns the ARN that records are sent to
+func asyncDestinationArn(destination map[string]interface{}) string {
+	if id := destination["destination_id"].(string); id != "" {
+		return id
+	}
+	return destination["queue_arn"].(string)
+}
+
+func expandAsyncDestination(async map[string]interface{}, key string) map[string]interface{} {
+	v, ok := async[key].([]interface{})
+	if !ok || len(v) == 0 {
+		return nil
+	}
+	if v[0] == nil {
+		// An empty block asks for a generated queue
+		destination := map[string]interface{}{"destination_id": "", "queue_url": "", "queue_arn": ""}
+		v[0] = destination
+	}
+	return v[0].(map[string]interface{})
+}
+
+// putFunctionAsync configures asynchronous invocation, creating queues for
+// destinations that need one and deleting those no longer needed
+func putFunctionAsync(d *schema.ResourceData, m interface{}, oldAsync, async map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	functionName := d.Get("function_name").(string)
+
+	statements := []*iamPolicyStatement{}
+	destinationConfig := &lambda.DestinationConfig{}
+	for _, key := range asyncDestinations {
+		destination := expandAsyncDestination(async, key)
+		var oldDestination map[string]interface{}
+		if oldAsync != nil {
+			oldDestination = expandAsyncDestination(oldAsync, key)
+		}
+
+		generated := destination != nil && destination["destination_id"].(string) == ""
+		oldGenerated := oldDestination != nil && oldDestination["destination_id"].(string) == "" && oldDestination["queue_url"].(string) != ""
+		switch {
+		case generated && oldGenerated:
+			destination["queue_url"] = oldDestination["queue_url"]
+			destination["queue_arn"] = oldDestination["queue_arn"]
+		case generated:
+			queueUrl, queueArn, err := createQueue(m.(*AWSClient).sqsconn, resource.UniqueId(), nil)
+			if err != nil {
+				return fmt.Errorf("Creating SQS queue for %s destination failed: %s", key, err)
+			}
+			destination["queue_url"] = queueUrl
+			destination["queue_arn"] = queueArn
+		}
+		if oldGenerated && !generated {
+			if err := deleteAsyncQueue(m, key, oldDestination); err != nil {
+				return err
+			}
+		}
+
+		if destination == nil {
+			continue
+		}
+		destinationArn := asyncDestinationArn(destination)
+		statement, err := asyncDestinationStatement(key, destinationArn)
+		if err != nil {
+			return err
+		}
+		statements = append(statements, statement)
+		if key == "on_success" {
+			destinationConfig.OnSuccess = &lambda.OnSuccess{Destination: aws.String(destinationArn)}
+		} else {
+			destinationConfig.OnFailure = &lambda.OnFailure{Destination: aws.String(destinationArn)}
+		}
+	}
+
+	// Lambda checks that the function's role can reach the destinations
+	err := putRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), destinationsPolicyName(functionName), statements)
+	if err != nil {
+		return err
+	}
+
+	input := &lambda.PutFunctionEventInvokeConfigInput{
+		FunctionName:             aws.String(functionName),
+		Qualifier:                functionQualifier(d),
+		MaximumRetryAttempts:     aws.Int64(int64(async["maximum_retry_attempts"].(int))),
+		MaximumEventAgeInSeconds: aws.Int64(int64(async["maximum_event_age"].(int))),
+		DestinationConfig:        destinationConfig,
+	}
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		_, err := conn.PutFunctionEventInvokeConfig(input)
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "permission") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error configuring asynchronous invocation of function %s: %s", functionName, err)
+	}
+	return nil
+}
+
+// asyncDestinationStatement grants the function what it needs to send
+// records to a destination
+func asyncDestinationStatement(key string, destinationArn string) (*iamPolicyStatement, error) {
+	var action string
+	switch {
+	case outputType(destinationArn) == outputTypeFunction:
+		action = "lambda:InvokeFunction"
+	case outputType(destinationArn) == outputTypePublisher:
+		action = "sns:Publish"
+	case isSqsArn(destinationArn):
+		action = "sqs:SendMessage"
+	default:
+		return nil, fmt.Errorf("async: %s destination %q is not the uri of a function or publisher", key, destinationArn)
+	}
+	return &iamPolicyStatement{
+		Effect:   "Allow",
+		Action:   []string{action},
+		Resource: []string{destinationArn},
+	}, nil
+}
+
+func isSqsArn(s string) bool {
+	parsed, err := arn.Parse(s)
+	return err == nil && parsed.Service == "sqs"
+}
+
+// deleteFunctionAsync removes the invoke config, the destinations policy and
+// any generated queues
+func deleteFunctionAsync(d *schema.ResourceData, m interface{}, async map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	functionName := d.Get("function_name").(string)
+
+	_, err := conn.DeleteFunctionEventInvokeConfig(&lambda.DeleteFunctionEventInvokeConfigInput{
+		FunctionName: aws.String(functionName),
+		Qualifier:    functionQualifier(d),
+	})
+	if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing asynchronous invocation config of function %s: %s", functionName, err)
+	}
+
+	if err := deleteRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), destinationsPolicyName(functionName)); err != nil {
+		return err
+	}
+
+	for _, key := range asyncDestinations {
+		destination := expandAsyncDestination(async, key)
+		if destination != nil && destination["destination_id"].(string) == "" {
+			if err := deleteAsyncQueue(m, key, destination); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func deleteAsyncQueue(m interface{}, key string, destination map[string]interface{}) error {
+	url := destination["queue_url"].(string)
+	if url == "" {
+		return nil
+	}
+	_, err := m.(*AWSClient).sqsconn.DeleteQueue(&sqs.DeleteQueueInput{
+		QueueUrl: aws.String(url),
+	})
+	if err != nil && !isAWSErr(err, sqs.ErrCodeQueueDoesNotExist, "") {
+		return fmt.Errorf("Error removing SQS queue of %s destination: %s", key, err)
+	}
+	return nil
+}
+
+// validateFunctionAsync checks that each destination is a function or a
+// publisher
+func validateFunctionAsync(diff *schema.ResourceDiff) error {
+	v := diff.Get("async").([]interface{})
+	if len(v) == 0 || v[0] == nil {
+		return nil
+	}
+	async := v[0].(map[string]interface{})
+	for _, key := range asyncDestinations {
+		destination := expandAsyncDestination(async, key)
+		if destination == nil {
+			continue
+		}
+		id := destination["destination_id"].(string)
+		if id == "" {
+			continue
+		}
+		if t := outputType(id); t != outputTypeFunction && t != outputTypePublisher {
+			return fmt.Errorf("async: %s destination %q is not the uri of a function or publisher", key, id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package plausible
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A build block compiles the function from source instead of uploading the
+// lambda.zip found there. Go functions are cross-compiled into a static
+// bootstrap binary for the provided runtimes. Builds are reproducible, so the
+// package is rebuilt when planning and its hash, which is the CodeSha256 that
+// Lambda reports, decides whether the code needs updating. Packages with
+// vendored dependencies are planned the same way.
+
+const buildLanguageGo = "go"
+
+var buildLanguages = []string{buildLanguageGo}
+
+// packageModTime is the modification time of every packaged file, which keeps
+// the package's hash independent of when it was built
+var packageModTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// functionArchitecture returns the function's architecture, which Lambda
+// defaults to x86_64
+func functionArchitecture(architectures interface{}) string {
+	if v := architectures.([]interface{}); len(v) > 0 {
+		return v[0].(string)
+	}
+	return lambda.ArchitectureX8664
+}
+
+func goArch(architecture string) string {
+	if architecture == lambda.ArchitectureArm64 {
+		return "arm64"
+	}
+	return "amd64"
+}
+
+// functionPackage returns the deployment package, building it if the function
+// has a build block and adding any dependencies
+func functionPackage(d *schema.ResourceData) ([]byte, error) {
+	return packageFunction(d.Get("source").(string), d.Get("runtime").(string), functionArchitecture(d.Get("architectures")), d.Get("build").([]interface{}), d.Get("dependencies").([]interface{}))
+}
+
+func packageFunction(source string, runtime string, architecture string, build []interface{}, dependencies []interface{}) ([]byte, error) {
+	if len(build) > 0 {
+		return buildFunction(source, build[0].(map[string]interface{}), architecture)
+	}
+
+	zipFilename := fmt.Sprintf("%s/lambda.zip", source)
+	file, err := loadFileContent(zipFilename)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to load %q: %s", zipFilename, err)
+	}
+	if len(dependencies) > 0 {
+		dir, err := installDependencies(source, dependencies[0].(map[string]interface{}), runtime, architecture)
+		if err != nil {
+			return nil, err
+		}
+		return packageDependencies(file, dir)
+	}
+	return file, nil
+}
+
+// updateFunctionCode uploads the current package and waits for Lambda to
+// finish with it, as later configuration changes would otherwise conflict
+func updateFunctionCode(d *schema.ResourceData, m interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	functionName := d.Get("function_name").(string)
+
+	code := &lambda.FunctionCode{ImageUri: aws.String(d.Get("image_uri").(string))}
+	if !isImageFunction(d) {
+		file, err := functionPackage(d)
+		if err != nil {
+			return err
+		}
+		code, err = functionCode(d, m, file)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := conn.UpdateFunctionCode(&lambda.UpdateFunctionCodeInput{
+		FunctionName:    aws.String(functionName),
+		ZipFile:         code.ZipFile,
+		S3Bucket:        code.S3Bucket,
+		S3Key:           code.S3Key,
+		S3ObjectVersion: code.S3ObjectVersion,
+		ImageUri:        code.ImageUri,
+		Architectures:   []*string{aws.String(functionArchitecture(d.Get("architectures")))},
+	})
+	if err != nil {
+		return fmt.Errorf("Error updating code of function %s: %s", functionName, err)
+	}
+	if code.S3Key != nil {
+		if err := deleteArtifacts(d, m, functionName, m.(*AWSClient).ArtifactRetention); err != nil {
+			return err
+		}
+	}
+	err = conn.WaitUntilFunctionUpdated(&lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for code update of function %s: %s", functionName, err)
+	}
+	return nil
+}
+
+func buildFunction(source string, build map[string]interface{}, architecture string) ([]byte, error) {
+	switch language := build["language"].(string); language {
+	case buildLanguageGo:
+		return buildGoFunction(source, build["package"].(string), architecture)
+	default:
+		return nil, fmt.Errorf("build: unsupported language %q", language)
+	}
+}
+
+// buildGoFunction compiles a Go package within the source module into a
+// packaged bootstrap
+func buildGoFunction(source string, pkg string, architecture string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "plausible-build")
+	if err != nil {
+		return nil, fmt.Errorf("Error creating build directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	bootstrap := filepath.Join(dir, "bootstrap")
+
+	cmd := exec.Command("go", "build",
+		"-trimpath",
+		"-buildvcs=false",
+		"-ldflags", "-s -w -buildid=",
+		"-tags", "lambda.norpc",
+		"-o", bootstrap,
+		pkg,
+	)
+	cmd.Dir = source
+	cmd.Env = append(os.Environ(),
+		"CGO_ENABLED=0",
+		"GOOS=linux",
+		"GOARCH="+goArch(architecture),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("Error building %s in %s: %s\n%s", pkg, source, err, output)
+	}
+	return packageBootstrap(bootstrap)
+}
+
+// packageBootstrap zips a bootstrap executable the same way every time
+func packageBootstrap(path string) ([]byte, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading build output: %s", err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	header := &zip.FileHeader{
+		Name:     "bootstrap",
+		Method:   zip.Deflate,
+		Modified: packageModTime,
+	}
+	header.SetMode(0755)
+	f, err := w.CreateHeader(header)
+	if err != nil {
+		return nil, fmt.Errorf("Error packaging build output: %s", err)
+	}
+	if _, err := f.Write(content); err != nil {
+		return nil, fmt.Errorf("Error packaging build output: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Error packaging build output: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// packageDirectory adds the files under dir to a package, except those
+// already in it. Files are added in a fixed order with fixed times, so that
+// the package's hash only changes with its content.
+func packageDirectory(w *zip.Writer, dir string, packaged map[string]bool) error {
+	paths := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if packaged[name] {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		header := &zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: packageModTime,
+		}
+		// Only whether a file is executable survives packaging
+		if info.Mode()&0111 != 0 {
+			header.SetMode(0755)
+		} else {
+			header.SetMode(0644)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageHash is the hash Lambda reports as the package's CodeSha256
+func packageHash(file []byte) string {
+	sum := sha256.Sum256(file)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// planFunctionPackage builds or vendors the function's package and plans a
+// code update if the result differs from what is deployed
+func planFunctionPackage(diff *schema.ResourceDiff) error {
+	build, dependencies := diff.Get("build").([]interface{}), diff.Get("dependencies").([]interface{})
+	if len(build) == 0 && len(dependencies) == 0 {
+		return nil
+	}
+	if !diff.NewValueKnown("source") || !diff.NewValueKnown("architectures") {
+		return nil
+	}
+	file, err := packageFunction(diff.Get("source").(string), diff.Get("runtime").(string), functionArchitecture(diff.Get("architectures")), build, dependencies)
+	if err != nil {
+		return err
+	}
+	if hash := packageHash(file); hash != diff.Get("source_code_hash").(string) {
+		return diff.SetNew("source_code_hash", hash)
+	}
+	return nil
+}
+
+// validateFunctionBuild checks that built functions run on a custom runtime,
+// which is what runs a bootstrap
+func validateFunctionBuild(diff *schema.ResourceDiff) error {
+	if _, ok := diff.GetOk("build"); !ok {
+		return nil
+	}
+	if runtime := diff.Get("runtime").(string); runtimeLanguage(runtime) != "provided" {
+		return fmt.Errorf("build: Go functions are built as a bootstrap, which needs a provided runtime, not %s", runtime)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package plausible
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readPackage returns the mode of each file in a package by name, in order
+func readPackage(t *testing.T, file []byte) ([]string, map[string]os.FileMode) {
+	archive, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	modes := map[string]os.FileMode{}
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+		modes[f.Name] = f.Mode()
+		if !f.Modified.Equal(packageModTime) {
+			t.Errorf("%s is modified at %s, not %s", f.Name, f.Modified, packageModTime)
+		}
+	}
+	return names, modes
+}
+
+func TestPackageBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "plausible-build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bootstrap")
+
+	write := func(content string, modified time.Time) []byte {
+		if err := ioutil.WriteFile(path, []byte(content), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+		file, err := packageBootstrap(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	first := write("binary", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	rebuilt := write("binary", time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+	changed := write("binary v2", time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	if packageHash(first) != packageHash(rebuilt) {
+		t.Error("rebuilding the same bootstrap changed the package hash")
+	}
+	if packageHash(first) == packageHash(changed) {
+		t.Error("changing the bootstrap did not change the package hash")
+	}
+
+	names, modes := readPackage(t, first)
+	if len(names) != 1 || names[0] != "bootstrap" {
+		t.Fatalf("got files %v, want only bootstrap", names)
+	}
+	if modes["bootstrap"].Perm() != 0755 {
+		t.Errorf("bootstrap has mode %s, want 0755", modes["bootstrap"].Perm())
+	}
+}
+
+func TestPackageDirectory(t *testing.T) {
+	files := []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"lib/b.py", 0600},
+		{"lib/a.py", 0644},
+		{"bin/tool", 0700},
+		{"app.py", 0664},
+	}
+
+	// Each copy writes the files in a different order and at different times
+	pack := func(order []int, modified time.Time, packaged map[string]bool) []byte {
+		dir, err := ioutil.TempDir("", "plausible-package")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		for _, i := range order {
+			path := filepath.Join(dir, filepath.FromSlash(files[i].name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(files[i].name), files[i].mode); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(path, modified, modified); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var buf bytes.Buffer
+		w := zip.NewWriter(&buf)
+		if err := packageDirectory(w, dir, packaged); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first := pack([]int{0, 1, 2, 3}, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	second := pack([]int{3, 2, 1, 0}, time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), nil)
+	if packageHash(first) != packageHash(second) {
+		t.Error("packaging the same files changed the package hash")
+	}
+
+	names, modes := readPackage(t, first)
+	want := []string{"app.py", "bin/tool", "lib/a.py", "lib/b.py"}
+	if len(names) != len(want) {
+		t.Fatalf("got files %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("got files %v, want %v", names, want)
+		}
+	}
+	for name, mode := range map[string]os.FileMode{"app.py": 0644, "bin/tool": 0755, "lib/a.py": 0644, "lib/b.py": 0644} {
+		if modes[name].Perm() != mode {
+			t.Errorf("%s has mode %s, want %s", name, modes[name].Perm(), mode)
+		}
+	}
+
+	skipped := pack([]int{0, 1, 2, 3}, time.Now(), map[string]bool{"app.py": true})
+	if names, _ := readPackage(t, skipped); len(names) != 3 || names[0] != "bin/tool" {
+		t.Errorf("got files %v, want app.py left out", names)
+	}
+}
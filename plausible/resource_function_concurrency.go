@@ -0,0 +1,242 @@
+package plausible
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/applicationautoscaling"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	// Allocating provisioned concurrency can take several minutes
+	provisionedConcurrencyTimeout = 15 * time.Minute
+
+	provisionedConcurrencyDimension = "lambda:function:ProvisionedConcurrency"
+)
+
+// Reserved concurrency caps the function as a whole. Provisioned concurrency
+// keeps instances of the deployment alias initialized, so that they answer
+// without a cold start, and can be scaled on a schedule through Application
+// Auto Scaling.
+
+// putFunctionReservedConcurrency reserves concurrency for the function, or
+// releases it when reserved_concurrency is -1
+func putFunctionReservedConcurrency(d *schema.ResourceData, m interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	functionName := d.Get("function_name").(string)
+	reserved := d.Get("reserved_concurrency").(int)
+
+	if reserved < 0 {
+		_, err := conn.DeleteFunctionConcurrency(&lambda.DeleteFunctionConcurrencyInput{
+			FunctionName: aws.String(functionName),
+		})
+		if err != nil {
+			return fmt.Errorf("Error removing reserved concurrency of function %s: %s", functionName, err)
+		}
+		return nil
+	}
+
+	_, err := conn.PutFunctionConcurrency(&lambda.PutFunctionConcurrencyInput{
+		FunctionName:                 aws.String(functionName),
+		ReservedConcurrentExecutions: aws.Int64(int64(reserved)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reserving concurrency for function %s: %s", functionName, err)
+	}
+	return nil
+}
+
+func readFunctionReservedConcurrency(d *schema.ResourceData, m interface{}) error {
+	out, err := m.(*AWSClient).lambdaconn.GetFunctionConcurrency(&lambda.GetFunctionConcurrencyInput{
+		FunctionName: aws.String(d.Get("function_name").(string)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading reserved concurrency of function %s: %s", d.Get("function_name"), err)
+	}
+	if out.ReservedConcurrentExecutions == nil {
+		d.Set("reserved_concurrency", -1)
+	} else {
+		d.Set("reserved_concurrency", out.ReservedConcurrentExecutions)
+	}
+	return nil
+}
+
+// putFunctionProvisionedConcurrency configures provisioned concurrency and
+// its scaling schedule on the deployment alias, and waits for it to be ready
+func putFunctionProvisionedConcurrency(d *schema.ResourceData, m interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	functionName := d.Get("function_name").(string)
+	deployments := d.Get("deployment").([]interface{})
+	deployment := deployments[0].(map[string]interface{})
+	aliasName := deployment["alias"].(string)
+	provisioned := deployment["provisioned_concurrency"].(int)
+
+	if err := deleteProvisionedConcurrencySchedule(d, m, aliasName); err != nil {
+		return err
+	}
+
+	if provisioned == 0 {
+		_, err := conn.DeleteProvisionedConcurrencyConfig(&lambda.DeleteProvisionedConcurrencyConfigInput{
+			FunctionName: aws.String(functionName),
+			Qualifier:    aws.String(aliasName),
+		})
+		if err != nil && !isAWSErr(err, lambda.ErrCodeProvisionedConcurrencyConfigNotFoundException, "") {
+			return fmt.Errorf("Error removing provisioned concurrency of alias %s: %s", aliasName, err)
+		}
+		deployment["provisioned_concurrency_status"] = ""
+		deployment["allocated_provisioned_concurrency"] = 0
+		d.Set("deployment", deployments)
+		return nil
+	}
+
+	_, err := conn.PutProvisionedConcurrencyConfig(&lambda.PutProvisionedConcurrencyConfigInput{
+		FunctionName:                    aws.String(functionName),
+		Qualifier:                       aws.String(aliasName),
+		ProvisionedConcurrentExecutions: aws.Int64(int64(provisioned)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error provisioning concurrency for alias %s: %s", aliasName, err)
+	}
+	if err := waitForProvisionedConcurrency(d, m, aliasName); err != nil {
+		return err
+	}
+
+	if err := putProvisionedConcurrencySchedule(d, m, aliasName, deployment); err != nil {
+		return err
+	}
+	return readFunctionProvisionedConcurrency(d, m)
+}
+
+func waitForProvisionedConcurrency(d *schema.ResourceData, m interface{}, aliasName string) error {
+	conn := m.(*AWSClient).lambdaconn
+	return resource.Retry(provisionedConcurrencyTimeout, func() *resource.RetryError {
+		out, err := conn.GetProvisionedConcurrencyConfig(&lambda.GetProvisionedConcurrencyConfigInput{
+			FunctionName: aws.String(d.Get("function_name").(string)),
+			Qualifier:    aws.String(aliasName),
+		})
+		if err != nil {
+			return resource.NonRetryableError(fmt.Errorf("Error reading provisioned concurrency of alias %s: %s", aliasName, err))
+		}
+		switch aws.StringValue(out.Status) {
+		case lambda.ProvisionedConcurrencyStatusEnumReady:
+			return nil
+		case lambda.ProvisionedConcurrencyStatusEnumFailed:
+			return resource.NonRetryableError(fmt.Errorf("Provisioning concurrency for alias %s failed: %s", aliasName, aws.StringValue(out.StatusReason)))
+		}
+		log.Printf("[DEBUG] Waiting for provisioned concurrency of alias %s: %d of %d allocated", aliasName, aws.Int64Value(out.AllocatedProvisionedConcurrentExecutions), aws.Int64Value(out.RequestedProvisionedConcurrentExecutions))
+		return resource.RetryableError(fmt.Errorf("provisioned concurrency of alias %s is %s", aliasName, aws.StringValue(out.Status)))
+	})
+}
+
+// readFunctionProvisionedConcurrency refreshes the allocation status of the
+// deployment alias
+func readFunctionProvisionedConcurrency(d *schema.ResourceData, m interface{}) error {
+	deployments := d.Get("deployment").([]interface{})
+	deployment := deployments[0].(map[string]interface{})
+	out, err := m.(*AWSClient).lambdaconn.GetProvisionedConcurrencyConfig(&lambda.GetProvisionedConcurrencyConfigInput{
+		FunctionName: aws.String(d.Get("function_name").(string)),
+		Qualifier:    aws.String(deployment["alias"].(string)),
+	})
+	if isAWSErr(err, lambda.ErrCodeProvisionedConcurrencyConfigNotFoundException, "") {
+		deployment["provisioned_concurrency_status"] = ""
+		deployment["allocated_provisioned_concurrency"] = 0
+		d.Set("deployment", deployments)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading provisioned concurrency of alias %s: %s", deployment["alias"], err)
+	}
+	deployment["provisioned_concurrency_status"] = aws.StringValue(out.Status)
+	deployment["allocated_provisioned_concurrency"] = int(aws.Int64Value(out.AllocatedProvisionedConcurrentExecutions))
+	d.Set("deployment", deployments)
+	return nil
+}
+
+func provisionedConcurrencyResourceId(d *schema.ResourceData, aliasName string) string {
+	return fmt.Sprintf("function:%s:%s", d.Get("function_name").(string), aliasName)
+}
+
+// putProvisionedConcurrencySchedule registers the alias with Application Auto
+// Scaling and adds a scheduled action for each scaling schedule
+func putProvisionedConcurrencySchedule(d *schema.ResourceData, m interface{}, aliasName string, deployment map[string]interface{}) error {
+	conn := m.(*AWSClient).applicationautoscalingconn
+	schedules := deployment["provisioned_concurrency_schedule"].([]interface{})
+	if len(schedules) == 0 {
+		return nil
+	}
+	resourceId := provisionedConcurrencyResourceId(d, aliasName)
+	provisioned := int64(deployment["provisioned_concurrency"].(int))
+
+	_, err := conn.RegisterScalableTarget(&applicationautoscaling.RegisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceLambda),
+		ResourceId:        aws.String(resourceId),
+		ScalableDimension: aws.String(provisionedConcurrencyDimension),
+		MinCapacity:       aws.Int64(provisioned),
+		MaxCapacity:       aws.Int64(provisioned),
+	})
+	if err != nil {
+		return fmt.Errorf("Error registering alias %s for scheduled scaling: %s", aliasName, err)
+	}
+
+	for _, s := range schedules {
+		schedule := s.(map[string]interface{})
+		input := &applicationautoscaling.PutScheduledActionInput{
+			ServiceNamespace:    aws.String(applicationautoscaling.ServiceNamespaceLambda),
+			ResourceId:          aws.String(resourceId),
+			ScalableDimension:   aws.String(provisionedConcurrencyDimension),
+			ScheduledActionName: aws.String(schedule["name"].(string)),
+			Schedule:            aws.String(schedule["schedule"].(string)),
+			ScalableTargetAction: &applicationautoscaling.ScalableTargetAction{
+				MinCapacity: aws.Int64(int64(schedule["min_capacity"].(int))),
+				MaxCapacity: aws.Int64(int64(schedule["max_capacity"].(int))),
+			},
+		}
+		if tz := schedule["timezone"].(string); tz != "" {
+			input.Timezone = aws.String(tz)
+		}
+		if _, err := conn.PutScheduledAction(input); err != nil {
+			return fmt.Errorf("Error putting scaling schedule %s on alias %s: %s", schedule["name"], aliasName, err)
+		}
+	}
+	return nil
+}
+
+// deleteProvisionedConcurrencySchedule deregisters the alias from Application
+// Auto Scaling, which also removes its scheduled actions
+func deleteProvisionedConcurrencySchedule(d *schema.ResourceData, m interface{}, aliasName string) error {
+	_, err := m.(*AWSClient).applicationautoscalingconn.DeregisterScalableTarget(&applicationautoscaling.DeregisterScalableTargetInput{
+		ServiceNamespace:  aws.String(applicationautoscaling.ServiceNamespaceLambda),
+		ResourceId:        aws.String(provisionedConcurrencyResourceId(d, aliasName)),
+		ScalableDimension: aws.String(provisionedConcurrencyDimension),
+	})
+	if err != nil && !isAWSErr(err, applicationautoscaling.ErrCodeObjectNotFoundException, "") {
+		return fmt.Errorf("Error deregistering alias %s from scheduled scaling: %s", aliasName, err)
+	}
+	return nil
+}
+
+// validateFunctionConcurrency checks that scaling schedules have provisioned
+// concurrency to scale, and that they keep min_capacity within max_capacity
+func validateFunctionConcurrency(diff *schema.ResourceDiff) error {
+	deployments := diff.Get("deployment").([]interface{})
+	if len(deployments) == 0 {
+		return nil
+	}
+	deployment := deployments[0].(map[string]interface{})
+	schedules := deployment["provisioned_concurrency_schedule"].([]interface{})
+	if len(schedules) > 0 && deployment["provisioned_concurrency"].(int) == 0 {
+		return fmt.Errorf("deployment: provisioned_concurrency_schedule requires provisioned_concurrency")
+	}
+	for _, s := range schedules {
+		schedule := s.(map[string]interface{})
+		if schedule["min_capacity"].(int) > schedule["max_capacity"].(int) {
+			return fmt.Errorf("deployment: provisioned_concurrency_schedule %q has min_capacity greater than max_capacity", schedule["name"])
+		}
+	}
+	return nil
+}
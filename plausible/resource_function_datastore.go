@@ -0,0 +1,529 @@
+package plausible
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	datastoreTypeKeyValue = "keyvalue"
+	datastoreTypeObject   = "object"
+
+	// Lambda permissions take a few seconds to become visible to S3, which
+	// validates the destination when the notification configuration is put
+	s3NotificationPropagationTimeout = 1 * time.Minute
+
+	// A newly enabled DynamoDB stream cannot be mapped until it is active
+	dynamodbStreamEnablingTimeout = 2 * time.Minute
+)
+
+// objectStoreEvents maps the object store trigger events to S3 event types
+var objectStoreEvents = map[string]string{
+	"created":  "s3:ObjectCreated:*",
+	"removed":  "s3:ObjectRemoved:*",
+	"restored": "s3:ObjectRestore:Completed",
+}
+
+func datastorePolicyName(functionName string) string {
+	return fmt.Sprintf("%s-datastore", functionName)
+}
+
+// datastoreType determines the kind of datastore from its id, which is the
+// uri of a plausible_keyvalue_store or plausible_object_store
+func datastoreType(datastoreId string) string {
+	switch {
+	case strings.Contains(strings.ToLower(datastoreId), ":dynamodb"):
+		return datastoreTypeKeyValue
+	case strings.Contains(strings.ToLower(datastoreId), ":s3"):
+		return datastoreTypeObject
+	}
+	return ""
+}
+
+func createDatastoreTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	// Resource creation depends on the type of datastore - key/value, object
+	switch datastoreType(triggerInfo["datastore_id"].(string)) {
+	case datastoreTypeKeyValue:
+		return createKeyValueStoreTrigger(d, m, triggerInfo)
+	case datastoreTypeObject:
+		return createObjectStoreTrigger(d, m, triggerInfo)
+	}
+	return fmt.Errorf("datastore_id %q is not the uri of a key-value store or object store", triggerInfo["datastore_id"])
+}
+
+func updateDatastoreTrigger(d *schema.ResourceData, m interface{}, oldInfo, triggerInfo map[string]interface{}) error {
+	oldType := datastoreType(oldInfo["datastore_id"].(string))
+	newType := datastoreType(triggerInfo["datastore_id"].(string))
+	switch {
+	case oldType != newType:
+		if err := deleteDatastoreTrigger(d, m, oldInfo); err != nil {
+			return err
+		}
+		return createDatastoreTrigger(d, m, triggerInfo)
+	case newType == datastoreTypeKeyValue:
+		return updateKeyValueStoreTrigger(d, m, oldInfo, triggerInfo)
+	case newType == datastoreTypeObject:
+		return updateObjectStoreTrigger(d, m, oldInfo, triggerInfo)
+	}
+	return nil
+}
+
+func deleteDatastoreTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	switch datastoreType(triggerInfo["datastore_id"].(string)) {
+	case datastoreTypeKeyValue:
+		return deleteKeyValueStoreTrigger(d, m, triggerInfo)
+	case datastoreTypeObject:
+		return deleteObjectStoreTrigger(d, m, triggerInfo)
+	}
+	return nil
+}
+
+// A key-value store trigger reads the table's DynamoDB stream through a Lambda
+// event source mapping. The stream is enabled if the table does not have one
+// yet, and an existing stream is reused, since a table can only have one.
+func createKeyValueStoreTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	ddbconn := m.(*AWSClient).dynamodbconn
+	tableArn, err := arn.Parse(triggerInfo["datastore_id"].(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing key-value store uri %q: %s", triggerInfo["datastore_id"], err)
+	}
+	tableName := strings.Split(tableArn.Resource, "/")[1]
+	viewType := triggerInfo["stream_view_type"].(string)
+
+	table, err := ddbconn.DescribeTable(&dynamodb.DescribeTableInput{
+		TableName: aws.String(tableName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading DynamoDB table %q: %s", tableName, err)
+	}
+
+	var streamArn string
+	if spec := table.Table.StreamSpecification; spec != nil && aws.BoolValue(spec.StreamEnabled) {
+		streamArn = aws.StringValue(table.Table.LatestStreamArn)
+		if aws.StringValue(spec.StreamViewType) != viewType {
+			log.Printf("[WARN] Reusing the %s stream of DynamoDB table %q, which differs from the requested %s", aws.StringValue(spec.StreamViewType), tableName, viewType)
+		}
+	} else {
+		// Enable dynamodb stream
+		updateOutput, err := ddbconn.UpdateTable(&dynamodb.UpdateTableInput{
+			TableName: aws.String(tableName),
+			StreamSpecification: &dynamodb.StreamSpecification{
+				StreamEnabled:  aws.Bool(true),
+				StreamViewType: aws.String(viewType),
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("Error enabling stream on DynamoDB table %q: %s", tableName, err)
+		}
+		streamArn = aws.StringValue(updateOutput.TableDescription.LatestStreamArn)
+	}
+	triggerInfo["stream_arn"] = streamArn
+
+	if err := putKeyValueStoreTriggerPolicy(d, m, triggerInfo); err != nil {
+		return err
+	}
+
+	// Create Lambda event source mapping
+	params := &lambda.CreateEventSourceMappingInput{
+		EventSourceArn:             aws.String(streamArn),
+		FunctionName:               aws.String(functionTargetArn(d)),
+		Enabled:                    aws.Bool(true),
+		StartingPosition:           aws.String(triggerInfo["starting_position"].(string)),
+		BatchSize:                  aws.Int64(int64(triggerInfo["batch_size"].(int))),
+		BisectBatchOnFunctionError: aws.Bool(triggerInfo["bisect_batch_on_error"].(bool)),
+		DestinationConfig:          expandKeyValueStoreDestination(triggerInfo),
+	}
+	if params.FilterCriteria, err = expandKeyValueStoreFilterCriteria(triggerInfo); err != nil {
+		return err
+	}
+
+	var mapping *lambda.EventSourceMappingConfiguration
+	err = resource.Retry(dynamodbStreamEnablingTimeout, func() *resource.RetryError {
+		var err error
+		mapping, err = conn.CreateEventSourceMapping(params)
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "Stream") {
+			return resource.RetryableError(err)
+		}
+		// The on_failure grant takes a while to propagate
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "permission") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Creating Lambda event source mapping: %s", err)
+	}
+	triggerInfo["event_source_mapping_id"] = aws.StringValue(mapping.UUID)
+
+	return nil
+}
+
+// updateKeyValueStoreTrigger changes the event source mapping in place. The
+// stream and starting position are fixed when the mapping is created, so
+// changing them replaces the trigger.
+func updateKeyValueStoreTrigger(d *schema.ResourceData, m interface{}, oldInfo, triggerInfo map[string]interface{}) error {
+	for _, key := range []string{"datastore_id", "stream_view_type", "starting_position"} {
+		if oldInfo[key].(string) != triggerInfo[key].(string) {
+			if err := deleteKeyValueStoreTrigger(d, m, oldInfo); err != nil {
+				return err
+			}
+			return createKeyValueStoreTrigger(d, m, triggerInfo)
+		}
+	}
+
+	conn := m.(*AWSClient).lambdaconn
+	triggerInfo["stream_arn"] = oldInfo["stream_arn"]
+	triggerInfo["event_source_mapping_id"] = oldInfo["event_source_mapping_id"]
+
+	params := &lambda.UpdateEventSourceMappingInput{
+		UUID:                       aws.String(triggerInfo["event_source_mapping_id"].(string)),
+		BatchSize:                  aws.Int64(int64(triggerInfo["batch_size"].(int))),
+		BisectBatchOnFunctionError: aws.Bool(triggerInfo["bisect_batch_on_error"].(bool)),
+		DestinationConfig:          expandKeyValueStoreDestination(triggerInfo),
+	}
+	var err error
+	if params.FilterCriteria, err = expandKeyValueStoreFilterCriteria(triggerInfo); err != nil {
+		return err
+	}
+	if params.FilterCriteria == nil {
+		// An empty set of filters removes filtering altogether
+		params.FilterCriteria = &lambda.FilterCriteria{Filters: []*lambda.Filter{}}
+	}
+	if params.DestinationConfig == nil {
+		params.DestinationConfig = &lambda.DestinationConfig{OnFailure: &lambda.OnFailure{}}
+	}
+
+	if err := putKeyValueStoreTriggerPolicy(d, m, triggerInfo); err != nil {
+		return err
+	}
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		_, err := conn.UpdateEventSourceMapping(params)
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "permission") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Updating Lambda event source mapping failed: %s", err)
+	}
+	return nil
+}
+
+// deleteKeyValueStoreTrigger removes the event source mapping. The stream is
+// left enabled, since other functions may be reading it.
+func deleteKeyValueStoreTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+
+	_, err := conn.DeleteEventSourceMapping(&lambda.DeleteEventSourceMappingInput{
+		UUID: aws.String(triggerInfo["event_source_mapping_id"].(string)),
+	})
+	if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing Lambda event source mapping: %s", err)
+	}
+	return deleteRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), datastorePolicyName(d.Get("function_name").(string)))
+}
+
+// putKeyValueStoreTriggerPolicy lets the function's role send the records
+// that failed to the on_failure destination, which Lambda checks when the
+// mapping is configured, and removes that grant otherwise
+func putKeyValueStoreTriggerPolicy(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	statements := []*iamPolicyStatement{}
+	if destinationArn := triggerInfo["on_failure"].(string); destinationArn != "" {
+		var action string
+		switch {
+		case outputType(destinationArn) == outputTypePublisher:
+			action = "sns:Publish"
+		case isSqsArn(destinationArn):
+			action = "sqs:SendMessage"
+		default:
+			return fmt.Errorf("datastore_trigger: on_failure %q is not the uri of a queue or publisher", destinationArn)
+		}
+		statements = append(statements, &iamPolicyStatement{
+			Effect:   "Allow",
+			Action:   []string{action},
+			Resource: []string{destinationArn},
+		})
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), datastorePolicyName(d.Get("function_name").(string)), statements)
+}
+
+func expandKeyValueStoreDestination(triggerInfo map[string]interface{}) *lambda.DestinationConfig {
+	if v, ok := triggerInfo["on_failure"]; ok && v.(string) != "" {
+		return &lambda.DestinationConfig{
+			OnFailure: &lambda.OnFailure{Destination: aws.String(v.(string))},
+		}
+	}
+	return nil
+}
+
+// expandKeyValueStoreFilterCriteria builds a Lambda event filter that only
+// passes stream records with one of the selected event types
+func expandKeyValueStoreFilterCriteria(triggerInfo map[string]interface{}) (*lambda.FilterCriteria, error) {
+	v, ok := triggerInfo["event_types"]
+	if !ok || v.(*schema.Set).Len() == 0 {
+		return nil, nil
+	}
+
+	eventNames := []string{}
+	for _, e := range v.(*schema.Set).List() {
+		eventNames = append(eventNames, e.(string))
+	}
+	pattern, err := json.Marshal(map[string]interface{}{
+		"eventName": eventNames,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error building event filter pattern: %s", err)
+	}
+
+	return &lambda.FilterCriteria{
+		Filters: []*lambda.Filter{
+			{Pattern: aws.String(string(pattern))},
+		},
+	}, nil
+}
+
+// An object store trigger requires a Lambda permission that allows S3 to
+// invoke the function, and an entry in the bucket's notification
+// configuration. The bucket has a single notification configuration, so the
+// entry is merged with those of other functions rather than replacing them.
+func createObjectStoreTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	bucketArn, err := arn.Parse(triggerInfo["datastore_id"].(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing object store uri %q: %s", triggerInfo["datastore_id"], err)
+	}
+	functionArn, err := arn.Parse(d.Id())
+	if err != nil {
+		return fmt.Errorf("Error parsing function ARN %q: %s", d.Id(), err)
+	}
+
+	// The notification id doubles as the permission statement id, so that both
+	// can be found again on delete
+	notificationId := resource.UniqueId()
+	triggerInfo["notification_id"] = notificationId
+
+	// Create Lambda permission
+	input := lambda.AddPermissionInput{
+		Action:        aws.String("lambda:InvokeFunction"),
+		FunctionName:  aws.String(functionTargetArn(d)),
+		Principal:     aws.String("s3.amazonaws.com"),
+		StatementId:   aws.String(notificationId),
+		SourceArn:     aws.String(bucketArn.String()),
+		SourceAccount: aws.String(functionArn.AccountID),
+	}
+	_, err = conn.AddPermission(&input)
+	if err != nil {
+		return fmt.Errorf("Error adding lambda permission %s", err)
+	}
+
+	return putObjectStoreNotification(m, bucketArn.Resource, functionTargetArn(d), triggerInfo)
+}
+
+// objectStoreTriggerFilters returns the key filters for the trigger, either
+// the prefix and suffix given directly or those compiled from its key path
+func objectStoreTriggerFilters(m interface{}, bucket string, triggerInfo map[string]interface{}) ([]keyPathFilter, error) {
+	path, ok := triggerInfo["key_path"].(string)
+	if !ok || path == "" {
+		return []keyPathFilter{{
+			Prefix: triggerInfo["prefix"].(string),
+			Suffix: triggerInfo["suffix"].(string),
+		}}, nil
+	}
+
+	item, err := registryGet(m.(*AWSClient).AppName, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("Error looking up key components of object store %q: %s", bucket, err)
+	}
+	keyComponents, err := decodeKeyComponents(item.Attributes["key_components"])
+	if err != nil {
+		return nil, err
+	}
+	filters, err := compileKeyPath(path, keyComponents)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling key_path for object store %q: %s", bucket, err)
+	}
+	return filters, nil
+}
+
+// updateObjectStoreTrigger replaces the function's entry in the bucket's
+// notification configuration. Pointing the trigger at a different object
+// store replaces the trigger.
+func updateObjectStoreTrigger(d *schema.ResourceData, m interface{}, oldInfo, triggerInfo map[string]interface{}) error {
+	if oldInfo["datastore_id"].(string) != triggerInfo["datastore_id"].(string) {
+		if err := deleteObjectStoreTrigger(d, m, oldInfo); err != nil {
+			return err
+		}
+		return createObjectStoreTrigger(d, m, triggerInfo)
+	}
+
+	bucketArn, err := arn.Parse(triggerInfo["datastore_id"].(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing object store uri %q: %s", triggerInfo["datastore_id"], err)
+	}
+	triggerInfo["notification_id"] = oldInfo["notification_id"]
+	return putObjectStoreNotification(m, bucketArn.Resource, functionTargetArn(d), triggerInfo)
+}
+
+func deleteObjectStoreTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	s3conn := m.(*AWSClient).s3conn
+	notificationId := triggerInfo["notification_id"].(string)
+	bucketArn, err := arn.Parse(triggerInfo["datastore_id"].(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing object store uri %q: %s", triggerInfo["datastore_id"], err)
+	}
+	bucket := bucketArn.Resource
+
+	plausibleMutexKV.Lock(bucket)
+	defer plausibleMutexKV.Unlock(bucket)
+
+	// Remove this function's entries from the bucket notification configuration
+	configuration, err := s3conn.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil && !isAWSErr(err, s3.ErrCodeNoSuchBucket, "") {
+		return fmt.Errorf("Error reading S3 bucket notification configuration: %s", err)
+	}
+	if err == nil {
+		configuration.LambdaFunctionConfigurations = withoutObjectStoreNotification(configuration.LambdaFunctionConfigurations, notificationId)
+		_, err = s3conn.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+			Bucket:                    aws.String(bucket),
+			NotificationConfiguration: configuration,
+		})
+		if err != nil {
+			return fmt.Errorf("Error removing S3 bucket notification: %s", err)
+		}
+	}
+
+	// Delete Lambda permission
+	_, err = conn.RemovePermission(&lambda.RemovePermissionInput{
+		FunctionName: aws.String(functionTargetArn(d)),
+		StatementId:  aws.String(notificationId),
+	})
+	if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing Lambda permission: %s", err)
+	}
+	return nil
+}
+
+// putObjectStoreNotification adds or replaces the function's entries in the
+// bucket notification configuration, leaving other destinations untouched
+func putObjectStoreNotification(m interface{}, bucket string, functionArn string, triggerInfo map[string]interface{}) error {
+	s3conn := m.(*AWSClient).s3conn
+	notificationId := triggerInfo["notification_id"].(string)
+
+	plausibleMutexKV.Lock(bucket)
+	defer plausibleMutexKV.Unlock(bucket)
+
+	configuration, err := s3conn.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading S3 bucket notification configuration: %s", err)
+	}
+
+	filters, err := objectStoreTriggerFilters(m, bucket, triggerInfo)
+	if err != nil {
+		return err
+	}
+
+	// A key path may compile to several filters, each of which needs its own
+	// notification configuration
+	lambdaConfigurations := withoutObjectStoreNotification(configuration.LambdaFunctionConfigurations, notificationId)
+	for i, filter := range filters {
+		id := notificationId
+		if len(filters) > 1 {
+			id = fmt.Sprintf("%s-%d", notificationId, i)
+		}
+		lambdaConfigurations = append(lambdaConfigurations, &s3.LambdaFunctionConfiguration{
+			Id:                aws.String(id),
+			LambdaFunctionArn: aws.String(functionArn),
+			Events:            expandObjectStoreEvents(triggerInfo),
+			Filter:            expandObjectStoreFilter(filter.Prefix, filter.Suffix),
+		})
+	}
+	configuration.LambdaFunctionConfigurations = lambdaConfigurations
+
+	err = resource.Retry(s3NotificationPropagationTimeout, func() *resource.RetryError {
+		_, err := s3conn.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+			Bucket:                    aws.String(bucket),
+			NotificationConfiguration: configuration,
+		})
+		if isAWSErr(err, "InvalidArgument", "Unable to validate the following destination configurations") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error putting S3 bucket notification: %s", err)
+	}
+	return nil
+}
+
+// withoutObjectStoreNotification returns the configurations, less any that
+// belong to the given notification id
+func withoutObjectStoreNotification(configurations []*s3.LambdaFunctionConfiguration, notificationId string) []*s3.LambdaFunctionConfiguration {
+	kept := []*s3.LambdaFunctionConfiguration{}
+	for _, c := range configurations {
+		id := aws.StringValue(c.Id)
+		if id != notificationId && !strings.HasPrefix(id, notificationId+"-") {
+			kept = append(kept, c)
+		}
+	}
+	return kept
+}
+
+func expandObjectStoreEvents(triggerInfo map[string]interface{}) []*string {
+	events := []*string{}
+	if v, ok := triggerInfo["events"]; ok && v.(*schema.Set).Len() > 0 {
+		for _, e := range v.(*schema.Set).List() {
+			events = append(events, aws.String(objectStoreEvents[e.(string)]))
+		}
+	} else {
+		events = append(events, aws.String(objectStoreEvents["created"]))
+	}
+	return events
+}
+
+func expandObjectStoreFilter(prefix string, suffix string) *s3.NotificationConfigurationFilter {
+	rules := []*s3.FilterRule{}
+	if prefix != "" {
+		rules = append(rules, &s3.FilterRule{
+			Name:  aws.String(s3.FilterRuleNamePrefix),
+			Value: aws.String(prefix),
+		})
+	}
+	if suffix != "" {
+		rules = append(rules, &s3.FilterRule{
+			Name:  aws.String(s3.FilterRuleNameSuffix),
+			Value: aws.String(suffix),
+		})
+	}
+	if len(rules) == 0 {
+		return nil
+	}
+	return &s3.NotificationConfigurationFilter{
+		Key: &s3.KeyFilter{FilterRules: rules},
+	}
+}
@@ -0,0 +1,176 @@
+package plausible
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A dependencies block vendors a Python function's requirements into its
+// package. pip installs them without touching the network, from a wheelhouse
+// directory or a local index, choosing wheels built for Lambda's platform.
+// Each set of installed requirements is cached under a hash of everything
+// that decides it, so plans and applies only install them once.
+
+// lambdaPlatforms are the pip platform tags of Lambda's architectures
+var lambdaPlatforms = map[string]string{
+	lambda.ArchitectureX8664: "manylinux2014_x86_64",
+	lambda.ArchitectureArm64: "manylinux2014_aarch64",
+}
+
+// dependenciesCacheDir is where installed requirements are kept
+func dependenciesCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("Error finding cache directory for dependencies: %s", err)
+	}
+	return filepath.Join(dir, "plausible", "dependencies"), nil
+}
+
+// dependenciesKey hashes the requirements together with where they are
+// installed from and what they are installed for
+func dependenciesKey(requirements []byte, dependencies map[string]interface{}, runtime string, architecture string) string {
+	h := sha256.New()
+	h.Write(requirements)
+	for _, v := range []string{dependencies["wheelhouse"].(string), dependencies["index_url"].(string), runtime, architecture} {
+		h.Write([]byte{0})
+		h.Write([]byte(v))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// installDependencies returns the directory the function's requirements are
+// installed in, installing them unless they are cached
+func installDependencies(source string, dependencies map[string]interface{}, runtime string, architecture string) (string, error) {
+	requirementsFile := filepath.Join(source, dependencies["requirements"].(string))
+	requirements, err := ioutil.ReadFile(requirementsFile)
+	if err != nil {
+		return "", fmt.Errorf("Error reading %s: %s", requirementsFile, err)
+	}
+
+	cacheDir, err := dependenciesCacheDir()
+	if err != nil {
+		return "", err
+	}
+	target := filepath.Join(cacheDir, dependenciesKey(requirements, dependencies, runtime, architecture))
+	if _, err := os.Stat(target); err == nil {
+		return target, nil
+	}
+
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", fmt.Errorf("Error creating cache directory for dependencies: %s", err)
+	}
+	staging, err := ioutil.TempDir(cacheDir, "install")
+	if err != nil {
+		return "", fmt.Errorf("Error creating directory for dependencies: %s", err)
+	}
+	defer os.RemoveAll(staging)
+
+	args := []string{"-m", "pip", "install",
+		"--requirement", requirementsFile,
+		"--target", staging,
+		"--platform", lambdaPlatforms[architecture],
+		"--implementation", "cp",
+		"--python-version", strings.TrimPrefix(runtime, "python"),
+		"--only-binary", ":all:",
+		"--no-compile",
+		"--disable-pip-version-check",
+	}
+	if wheelhouse := dependencies["wheelhouse"].(string); wheelhouse != "" {
+		args = append(args, "--no-index", "--find-links", wheelhouse)
+	} else {
+		args = append(args, "--index-url", dependencies["index_url"].(string))
+	}
+	cmd := exec.Command("python3", args...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("Error installing dependencies from %s: %s\n%s", requirementsFile, err, output)
+	}
+
+	// Another plan may have installed the same requirements meanwhile, in
+	// which case either copy will do
+	if err := os.Rename(staging, target); err != nil {
+		if _, statErr := os.Stat(target); statErr != nil {
+			return "", fmt.Errorf("Error caching dependencies: %s", err)
+		}
+	}
+	return target, nil
+}
+
+// packageDependencies adds the installed dependencies to a function package.
+// Files in the package take precedence over those of its dependencies.
+func packageDependencies(file []byte, dir string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading function package: %s", err)
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	packaged := map[string]bool{}
+	for _, f := range archive.File {
+		if err := w.Copy(f); err != nil {
+			return nil, fmt.Errorf("Error packaging function: %s", err)
+		}
+		packaged[f.Name] = true
+	}
+
+	if err := packageDirectory(w, dir, packaged); err != nil {
+		return nil, fmt.Errorf("Error packaging dependencies: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Error packaging dependencies: %s", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// isLocalIndex reports whether pip can reach an index without the network
+func isLocalIndex(index string) bool {
+	u, err := url.Parse(index)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "file":
+		return true
+	case "http", "https":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return false
+}
+
+// validateFunctionDependencies checks that dependencies are only vendored for
+// Python functions, from exactly one local source
+func validateFunctionDependencies(diff *schema.ResourceDiff) error {
+	v, ok := diff.GetOk("dependencies")
+	if !ok {
+		return nil
+	}
+	if runtime := diff.Get("runtime").(string); runtimeLanguage(runtime) != "python" {
+		return fmt.Errorf("dependencies: requirements are only installed for Python functions, not %s", runtime)
+	}
+	if _, ok := diff.GetOk("build"); ok {
+		return fmt.Errorf("dependencies: cannot be combined with build")
+	}
+
+	dependencies := v.([]interface{})[0].(map[string]interface{})
+	wheelhouse, index := dependencies["wheelhouse"].(string), dependencies["index_url"].(string)
+	if (wheelhouse == "") == (index == "") {
+		return fmt.Errorf("dependencies: exactly one of wheelhouse and index_url must be set")
+	}
+	if index != "" && !isLocalIndex(index) {
+		return fmt.Errorf("dependencies: index_url %q is not a file: URL or an index on localhost", index)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package plausible
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDependenciesKey(t *testing.T) {
+	base := map[string]interface{}{"wheelhouse": "wheels", "index_url": ""}
+	key := dependenciesKey([]byte("requests==2.31.0\n"), base, "python3.12", "x86_64")
+
+	if again := dependenciesKey([]byte("requests==2.31.0\n"), map[string]interface{}{"wheelhouse": "wheels", "index_url": ""}, "python3.12", "x86_64"); again != key {
+		t.Error("the same requirements have different keys")
+	}
+
+	cases := []struct {
+		name         string
+		requirements string
+		dependencies map[string]interface{}
+		runtime      string
+		architecture string
+	}{
+		{"requirements", "requests==2.32.0\n", base, "python3.12", "x86_64"},
+		{"wheelhouse", "requests==2.31.0\n", map[string]interface{}{"wheelhouse": "vendor", "index_url": ""}, "python3.12", "x86_64"},
+		{"index", "requests==2.31.0\n", map[string]interface{}{"wheelhouse": "", "index_url": "file:///wheels"}, "python3.12", "x86_64"},
+		{"runtime", "requests==2.31.0\n", base, "python3.13", "x86_64"},
+		{"architecture", "requests==2.31.0\n", base, "python3.12", "arm64"},
+		// Fields are separated, so moving text between them changes the key
+		{"boundary", "requests==2.31.0\n", map[string]interface{}{"wheelhouse": "wheel", "index_url": "s"}, "python3.12", "x86_64"},
+	}
+	for _, c := range cases {
+		if dependenciesKey([]byte(c.requirements), c.dependencies, c.runtime, c.architecture) == key {
+			t.Errorf("changing the %s did not change the key", c.name)
+		}
+	}
+}
+
+func TestIsLocalIndex(t *testing.T) {
+	cases := map[string]bool{
+		"file:///srv/wheels":            true,
+		"http://localhost:8080/simple":  true,
+		"https://127.0.0.1/simple":      true,
+		"http://[::1]:3141/root/pypi":   true,
+		"https://pypi.org/simple":       false,
+		"http://localhost.example.com/": false,
+		"ftp://localhost/simple":        false,
+		"/srv/wheels":                   false,
+		"://not a url":                  false,
+	}
+	for index, local := range cases {
+		if got := isLocalIndex(index); got != local {
+			t.Errorf("isLocalIndex(%q) = %t, want %t", index, got, local)
+		}
+	}
+}
+
+func TestPackageDependencies(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	f, err := w.Create("requests/__init__.py")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("# the function's own copy"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "plausible-dependencies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{"requests/__init__.py", "urllib3/__init__.py"} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("# installed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	file, err := packageDependencies(buf.Bytes(), dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents := map[string]string{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := contents[f.Name]; ok {
+			t.Errorf("%s is packaged twice", f.Name)
+		}
+		contents[f.Name] = string(content)
+	}
+	if contents["requests/__init__.py"] != "# the function's own copy" {
+		t.Errorf("the function's file was replaced by its dependency's")
+	}
+	if contents["urllib3/__init__.py"] != "# installed" {
+		t.Errorf("the dependency was not packaged")
+	}
+}
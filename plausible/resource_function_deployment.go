@@ -0,0 +1,240 @@
+package plausible
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	deploymentAllAtOnce = "all_at_once"
+	deploymentCanary    = "canary"
+	deploymentLinear    = "linear"
+
+	// How often the alarms are checked while traffic is shifting
+	deploymentAlarmPollInterval = 30 * time.Second
+	// How long to wait for a configuration update before publishing
+	functionUpdateTimeout = 5 * time.Minute
+)
+
+// A function with a deployment block is invoked through an alias, and each
+// update publishes a version that the alias is moved to. Canary and linear
+// deployments move the alias gradually, by routing a weight of its traffic to
+// the new version, and move it back if any of the alarms fire on the way.
+
+// functionTargetArn is the ARN that triggers invoke: the deployment alias
+// when there is one, or else the unqualified function
+func functionTargetArn(d *schema.ResourceData) string {
+	if v, ok := d.GetOk("deployment"); ok && len(v.([]interface{})) > 0 {
+		deployment := v.([]interface{})[0].(map[string]interface{})
+		if aliasArn := deployment["alias_arn"].(string); aliasArn != "" {
+			return aliasArn
+		}
+	}
+	return d.Id()
+}
+
+// functionQualifier is the deployment alias that triggers invoke, or nil for
+// functions without a deployment
+func functionQualifier(d *schema.ResourceData) *string {
+	if v, ok := d.GetOk("deployment"); ok {
+		return aws.String(v.([]interface{})[0].(map[string]interface{})["alias"].(string))
+	}
+	return nil
+}
+
+// publishFunctionVersion publishes the function's current code and
+// configuration. Lambda returns the latest version when nothing has changed.
+func publishFunctionVersion(d *schema.ResourceData, m interface{}) (string, error) {
+	conn := m.(*AWSClient).lambdaconn
+
+	var out *lambda.FunctionConfiguration
+	err := resource.Retry(functionUpdateTimeout, func() *resource.RetryError {
+		var err error
+		out, err = conn.PublishVersion(&lambda.PublishVersionInput{
+			FunctionName: aws.String(d.Get("function_name").(string)),
+		})
+		if isAWSErr(err, lambda.ErrCodeResourceConflictException, "") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error publishing version of function %s: %s", d.Get("function_name"), err)
+	}
+	return aws.StringValue(out.Version), nil
+}
+
+// deployFunction moves the deployment alias to version, creating the alias
+// if it does not exist yet
+func deployFunction(d *schema.ResourceData, m interface{}, version string) error {
+	conn := m.(*AWSClient).lambdaconn
+	functionName := d.Get("function_name").(string)
+	deployments := d.Get("deployment").([]interface{})
+	deployment := deployments[0].(map[string]interface{})
+	aliasName := deployment["alias"].(string)
+
+	alias, err := conn.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(aliasName),
+	})
+	if isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		alias, err = conn.CreateAlias(&lambda.CreateAliasInput{
+			FunctionName:    aws.String(functionName),
+			Name:            aws.String(aliasName),
+			FunctionVersion: aws.String(version),
+		})
+		if err != nil {
+			return fmt.Errorf("Error creating alias %s: %s", aliasName, err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("Error reading alias %s: %s", aliasName, err)
+	} else if aws.StringValue(alias.FunctionVersion) != version {
+		if err := shiftAliasTraffic(d, m, deployment, aws.StringValue(alias.FunctionVersion), version); err != nil {
+			return err
+		}
+	}
+
+	deployment["alias_arn"] = aws.StringValue(alias.AliasArn)
+	d.Set("deployment", deployments)
+	d.Set("version", version)
+	return nil
+}
+
+// deploymentWeights are the shares of traffic routed to the new version
+// before it takes all of it
+func deploymentWeights(deployment map[string]interface{}) []float64 {
+	percentage := deployment["percentage"].(int)
+	switch deployment["strategy"].(string) {
+	case deploymentCanary:
+		return []float64{float64(percentage) / 100}
+	case deploymentLinear:
+		weights := []float64{}
+		for p := percentage; p < 100; p += percentage {
+			weights = append(weights, float64(p)/100)
+		}
+		return weights
+	}
+	return nil
+}
+
+func shiftAliasTraffic(d *schema.ResourceData, m interface{}, deployment map[string]interface{}, from, to string) error {
+	aliasName := deployment["alias"].(string)
+	interval := time.Duration(deployment["interval"].(int)) * time.Minute
+	alarms := []*string{}
+	for _, a := range deployment["alarms"].([]interface{}) {
+		alarms = append(alarms, aws.String(a.(string)))
+	}
+
+	for _, weight := range deploymentWeights(deployment) {
+		log.Printf("[DEBUG] Routing %.0f%% of alias %s to version %s", weight*100, aliasName, to)
+		err := updateFunctionAlias(d, m, aliasName, from, map[string]*float64{to: aws.Float64(weight)})
+		if err != nil {
+			return err
+		}
+
+		deadline := time.Now().Add(interval)
+		for {
+			fired, err := deploymentAlarmFired(m, alarms)
+			if err != nil {
+				return err
+			}
+			if fired != "" {
+				if err := updateFunctionAlias(d, m, aliasName, from, nil); err != nil {
+					return fmt.Errorf("Alarm %s fired while deploying version %s, and rolling back failed: %s", fired, to, err)
+				}
+				return fmt.Errorf("Alarm %s fired while deploying version %s, so alias %s was rolled back to version %s", fired, to, aliasName, from)
+			}
+			if !time.Now().Before(deadline) {
+				break
+			}
+			time.Sleep(deploymentAlarmPollInterval)
+		}
+	}
+
+	return updateFunctionAlias(d, m, aliasName, to, nil)
+}
+
+// updateFunctionAlias points the alias at version, routing the given weights
+// to other versions
+func updateFunctionAlias(d *schema.ResourceData, m interface{}, aliasName string, version string, weights map[string]*float64) error {
+	conn := m.(*AWSClient).lambdaconn
+	if weights == nil {
+		weights = map[string]*float64{}
+	}
+	_, err := conn.UpdateAlias(&lambda.UpdateAliasInput{
+		FunctionName:    aws.String(d.Get("function_name").(string)),
+		Name:            aws.String(aliasName),
+		FunctionVersion: aws.String(version),
+		RoutingConfig: &lambda.AliasRoutingConfiguration{
+			AdditionalVersionWeights: weights,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error updating alias %s: %s", aliasName, err)
+	}
+	return nil
+}
+
+// deploymentAlarmFired returns the name of an alarm that is in the ALARM
+// state, if any
+func deploymentAlarmFired(m interface{}, alarms []*string) (string, error) {
+	if len(alarms) == 0 {
+		return "", nil
+	}
+	out, err := m.(*AWSClient).cloudwatchconn.DescribeAlarms(&cloudwatch.DescribeAlarmsInput{
+		AlarmNames: alarms,
+		AlarmTypes: aws.StringSlice([]string{cloudwatch.AlarmTypeMetricAlarm, cloudwatch.AlarmTypeCompositeAlarm}),
+		StateValue: aws.String(cloudwatch.StateValueAlarm),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error reading CloudWatch alarms: %s", err)
+	}
+	for _, a := range out.MetricAlarms {
+		return aws.StringValue(a.AlarmName), nil
+	}
+	for _, a := range out.CompositeAlarms {
+		return aws.StringValue(a.AlarmName), nil
+	}
+	return "", nil
+}
+
+// readFunctionDeployment refreshes the version the alias points at
+func readFunctionDeployment(d *schema.ResourceData, m interface{}) error {
+	deployments := d.Get("deployment").([]interface{})
+	deployment := deployments[0].(map[string]interface{})
+	alias, err := m.(*AWSClient).lambdaconn.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(d.Get("function_name").(string)),
+		Name:         aws.String(deployment["alias"].(string)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading alias %s: %s", deployment["alias"], err)
+	}
+	deployment["alias_arn"] = aws.StringValue(alias.AliasArn)
+	d.Set("deployment", deployments)
+	d.Set("version", alias.FunctionVersion)
+	return nil
+}
+
+// validateFunctionDeployment checks that a deployed function publishes
+// versions. The triggers are bound to the alias, so adding, removing or
+// renaming it replaces the function.
+func validateFunctionDeployment(diff *schema.ResourceDiff) error {
+	deployments := diff.Get("deployment").([]interface{})
+	if len(deployments) > 0 && !diff.Get("publish").(bool) {
+		return fmt.Errorf("deployment: requires publish to be true")
+	}
+	if diff.Id() != "" && diff.HasChange("deployment.0.alias") {
+		return diff.ForceNew("deployment.0.alias")
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package plausible
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A function with an image_uri runs a container image from ECR instead of a
+// zip of its source. The tag is resolved to a digest when planning, which
+// Lambda reports as the function's CodeSha256, so pushing a new image under
+// the same tag updates the function like a change to its source does. The
+// image's repository gets a statement letting Lambda pull from it.
+
+// imagePullSid is the repository policy statement that lets Lambda pull
+const imagePullSid = "PlausibleLambdaPull"
+
+var imageUriPattern = regexp.MustCompile(`^(\d{12})\.dkr\.ecr\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?/([^:@]+)(:([^@]+))?(@(sha256:[0-9a-f]{64}))?$`)
+
+type imageReference struct {
+	RegistryId string
+	Region     string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+func parseImageUri(uri string) (*imageReference, error) {
+	match := imageUriPattern.FindStringSubmatch(uri)
+	if match == nil {
+		return nil, fmt.Errorf("image_uri: %q is not an ECR image", uri)
+	}
+	image := &imageReference{
+		RegistryId: match[1],
+		Region:     match[2],
+		Repository: match[4],
+		Tag:        match[6],
+		Digest:     match[8],
+	}
+	if image.Tag == "" && image.Digest == "" {
+		image.Tag = "latest"
+	}
+	return image, nil
+}
+
+// isImageFunction reports whether a function runs a container image
+func isImageFunction(d *schema.ResourceData) bool {
+	_, ok := d.GetOk("image_uri")
+	return ok
+}
+
+// resolveImageDigest returns the digest an image URI currently refers to
+func resolveImageDigest(m interface{}, image *imageReference) (string, error) {
+	if image.Digest != "" {
+		return image.Digest, nil
+	}
+	out, err := m.(*AWSClient).ecrconn.DescribeImages(&ecr.DescribeImagesInput{
+		RegistryId:     aws.String(image.RegistryId),
+		RepositoryName: aws.String(image.Repository),
+		ImageIds:       []*ecr.ImageIdentifier{{ImageTag: aws.String(image.Tag)}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error resolving image %s:%s: %s", image.Repository, image.Tag, err)
+	}
+	if len(out.ImageDetails) == 0 {
+		return "", fmt.Errorf("image %s:%s does not exist", image.Repository, image.Tag)
+	}
+	return aws.StringValue(out.ImageDetails[0].ImageDigest), nil
+}
+
+func expandImageConfig(d *schema.ResourceData) *lambda.ImageConfig {
+	config := &lambda.ImageConfig{}
+	if v, ok := d.GetOk("image_config"); ok && v.([]interface{})[0] != nil {
+		c := v.([]interface{})[0].(map[string]interface{})
+		if command := c["command"].([]interface{}); len(command) > 0 {
+			config.Command = expandStringList(command)
+		}
+		if entrypoint := c["entrypoint"].([]interface{}); len(entrypoint) > 0 {
+			config.EntryPoint = expandStringList(entrypoint)
+		}
+		if workingDirectory := c["working_directory"].(string); workingDirectory != "" {
+			config.WorkingDirectory = aws.String(workingDirectory)
+		}
+	}
+	return config
+}
+
+func flattenImageConfig(config *lambda.ImageConfigResponse) []interface{} {
+	if config == nil || config.ImageConfig == nil {
+		return []interface{}{}
+	}
+	return []interface{}{map[string]interface{}{
+		"command":           aws.StringValueSlice(config.ImageConfig.Command),
+		"entrypoint":        aws.StringValueSlice(config.ImageConfig.EntryPoint),
+		"working_directory": aws.StringValue(config.ImageConfig.WorkingDirectory),
+	}}
+}
+
+// putImagePullPolicy adds a statement to the image's repository policy that
+// lets Lambda pull images for functions in the app's account, keeping the
+// policy's other statements
+func putImagePullPolicy(d *schema.ResourceData, m interface{}) error {
+	conn := m.(*AWSClient).ecrconn
+	image, err := parseImageUri(d.Get("image_uri").(string))
+	if err != nil {
+		return err
+	}
+
+	policy := map[string]interface{}{"Version": "2012-10-17"}
+	statements := []interface{}{}
+	out, err := conn.GetRepositoryPolicy(&ecr.GetRepositoryPolicyInput{
+		RegistryId:     aws.String(image.RegistryId),
+		RepositoryName: aws.String(image.Repository),
+	})
+	switch {
+	case isAWSErr(err, ecr.ErrCodeRepositoryPolicyNotFoundException, ""):
+	case err != nil:
+		return fmt.Errorf("Error reading policy of repository %s: %s", image.Repository, err)
+	default:
+		if err := json.Unmarshal([]byte(aws.StringValue(out.PolicyText)), &policy); err != nil {
+			return fmt.Errorf("Error decoding policy of repository %s: %s", image.Repository, err)
+		}
+		if v, ok := policy["Statement"].([]interface{}); ok {
+			statements = v
+		}
+	}
+
+	kept := []interface{}{}
+	for _, s := range statements {
+		if statement, ok := s.(map[string]interface{}); ok && statement["Sid"] == imagePullSid {
+			continue
+		}
+		kept = append(kept, s)
+	}
+	policy["Statement"] = append(kept, &iamPolicyStatement{
+		Sid:       imagePullSid,
+		Effect:    "Allow",
+		Principal: map[string]string{"Service": "lambda.amazonaws.com"},
+		Action:    []string{"ecr:BatchGetImage", "ecr:GetDownloadUrlForLayer"},
+		Condition: map[string]map[string]string{
+			"StringLike": {
+				"aws:sourceArn": fmt.Sprintf("arn:%s:lambda:%s:%s:function:*", m.(*AWSClient).partition, image.Region, d.Get("account_id").(string)),
+			},
+		},
+	})
+	b, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("Error encoding policy of repository %s: %s", image.Repository, err)
+	}
+
+	_, err = conn.SetRepositoryPolicy(&ecr.SetRepositoryPolicyInput{
+		RegistryId:     aws.String(image.RegistryId),
+		RepositoryName: aws.String(image.Repository),
+		PolicyText:     aws.String(string(b)),
+	})
+	if err != nil {
+		return fmt.Errorf("Error setting policy of repository %s: %s", image.Repository, err)
+	}
+	return nil
+}
+
+// planFunctionImage resolves the image's digest and plans a code update if
+// it differs from what is deployed
+func planFunctionImage(diff *schema.ResourceDiff, m interface{}) error {
+	// Lambda cannot change how a function is packaged
+	if o, n := diff.GetChange("image_uri"); diff.Id() != "" && (o.(string) == "") != (n.(string) == "") {
+		if err := diff.ForceNew("image_uri"); err != nil {
+			return err
+		}
+	}
+
+	v, ok := diff.GetOk("image_uri")
+	if !ok || !diff.NewValueKnown("image_uri") {
+		return nil
+	}
+	image, err := parseImageUri(v.(string))
+	if err != nil {
+		return err
+	}
+	digest, err := resolveImageDigest(m, image)
+	if err != nil {
+		return err
+	}
+	if hash := strings.TrimPrefix(digest, "sha256:"); hash != diff.Get("source_code_hash").(string) {
+		return diff.SetNew("source_code_hash", hash)
+	}
+	return nil
+}
+
+// validateFunctionImage checks that image functions are not also given the
+// settings of zip functions
+func validateFunctionImage(diff *schema.ResourceDiff) error {
+	v, ok := diff.GetOk("image_uri")
+	if !ok {
+		if _, ok := diff.GetOk("image_config"); ok {
+			return fmt.Errorf("image_config: requires image_uri")
+		}
+		return nil
+	}
+	if diff.NewValueKnown("image_uri") {
+		if _, err := parseImageUri(v.(string)); err != nil {
+			return err
+		}
+	}
+	for _, key := range []string{"build", "dependencies", "layers"} {
+		if _, ok := diff.GetOk(key); ok {
+			return fmt.Errorf("%s: cannot be used with image_uri", key)
+		}
+	}
+	return nil
+}
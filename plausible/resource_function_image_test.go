@@ -0,0 +1,58 @@
+package plausible
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseImageUri(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	cases := []struct {
+		uri   string
+		image *imageReference
+	}{
+		{
+			uri:   "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1",
+			image: &imageReference{RegistryId: "123456789012", Region: "us-east-1", Repository: "app", Tag: "v1"},
+		},
+		{
+			uri:   "123456789012.dkr.ecr.eu-west-2.amazonaws.com/team/app",
+			image: &imageReference{RegistryId: "123456789012", Region: "eu-west-2", Repository: "team/app", Tag: "latest"},
+		},
+		{
+			uri:   "123456789012.dkr.ecr.us-east-1.amazonaws.com/app@" + digest,
+			image: &imageReference{RegistryId: "123456789012", Region: "us-east-1", Repository: "app", Digest: digest},
+		},
+		{
+			uri:   "123456789012.dkr.ecr.us-east-1.amazonaws.com/app:v1@" + digest,
+			image: &imageReference{RegistryId: "123456789012", Region: "us-east-1", Repository: "app", Tag: "v1", Digest: digest},
+		},
+		{
+			uri:   "123456789012.dkr.ecr.cn-north-1.amazonaws.com.cn/app:v1",
+			image: &imageReference{RegistryId: "123456789012", Region: "cn-north-1", Repository: "app", Tag: "v1"},
+		},
+		{uri: "docker.io/library/python:3.12"},
+		{uri: "public.ecr.aws/lambda/python:3.12"},
+		{uri: "12345.dkr.ecr.us-east-1.amazonaws.com/app:v1"},
+		{uri: "123456789012.dkr.ecr.us-east-1.amazonaws.com/app@sha256:abc"},
+		{uri: "123456789012.dkr.ecr.us-east-1.amazonaws.com/"},
+		{uri: ""},
+	}
+	for _, c := range cases {
+		t.Run(c.uri, func(t *testing.T) {
+			image, err := parseImageUri(c.uri)
+			if c.image == nil {
+				if err == nil {
+					t.Fatalf("expected an error, got %+v", image)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(image, c.image) {
+				t.Fatalf("got %+v, want %+v", image, c.image)
+			}
+		})
+	}
+}
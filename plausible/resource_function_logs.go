@@ -0,0 +1,267 @@
+package plausible
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// The function's log group is created before the function, so that Lambda
+// does not create one of its own that never expires, and is deleted with it.
+// A log subscription forwards the group's events to another function, which
+// gets a permission for CloudWatch Logs to invoke it, or to a Firehose
+// stream, which CloudWatch Logs writes to as the existing PlausibleLogsRole.
+
+// logRetentionDays are the retention periods that CloudWatch Logs accepts,
+// with 0 keeping events forever
+var logRetentionDays = []int{0, 1, 3, 5, 7, 14, 30, 60, 90, 120, 150, 180, 365, 400, 545, 731, 1096, 1827, 2192, 2557, 2922, 3288, 3653}
+
+func functionLogGroupName(functionName string) string {
+	return fmt.Sprintf("/aws/lambda/%s", functionName)
+}
+
+func logSubscriptionFilterName(functionName string) string {
+	return fmt.Sprintf("%s-forward", functionName)
+}
+
+func logsPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-logs", functionName)
+}
+
+func logsRoleArn(d *schema.ResourceData, m interface{}) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/PlausibleLogsRole", m.(*AWSClient).partition, d.Get("account_id").(string))
+}
+
+// createFunctionLogGroup creates the log group, taking over one that Lambda
+// already created for a function of the same name
+func createFunctionLogGroup(d *schema.ResourceData, m interface{}) error {
+	logsconn := m.(*AWSClient).logsconn
+	logGroupName := functionLogGroupName(d.Get("function_name").(string))
+
+	input := &cloudwatchlogs.CreateLogGroupInput{
+		LogGroupName: aws.String(logGroupName),
+	}
+	if v, ok := d.GetOk("log_kms_key_arn"); ok {
+		input.KmsKeyId = aws.String(v.(string))
+	}
+	_, err := logsconn.CreateLogGroup(input)
+	if isAWSErr(err, cloudwatchlogs.ErrCodeResourceAlreadyExistsException, "") {
+		return updateFunctionLogGroup(d, m)
+	}
+	if err != nil {
+		return fmt.Errorf("Error creating log group %s: %s", logGroupName, err)
+	}
+	return putLogRetention(d, m)
+}
+
+// updateFunctionLogGroup applies the retention and encryption settings
+func updateFunctionLogGroup(d *schema.ResourceData, m interface{}) error {
+	logsconn := m.(*AWSClient).logsconn
+	logGroupName := functionLogGroupName(d.Get("function_name").(string))
+
+	// Only a group that was encrypted has a key to remove
+	o, n := d.GetChange("log_kms_key_arn")
+	switch oldKmsKeyArn, kmsKeyArn := o.(string), n.(string); {
+	case !d.HasChange("log_kms_key_arn"):
+	case kmsKeyArn != "":
+		_, err := logsconn.AssociateKmsKey(&cloudwatchlogs.AssociateKmsKeyInput{
+			LogGroupName: aws.String(logGroupName),
+			KmsKeyId:     aws.String(kmsKeyArn),
+		})
+		if err != nil {
+			return fmt.Errorf("Error encrypting log group %s: %s", logGroupName, err)
+		}
+	case oldKmsKeyArn != "":
+		_, err := logsconn.DisassociateKmsKey(&cloudwatchlogs.DisassociateKmsKeyInput{
+			LogGroupName: aws.String(logGroupName),
+		})
+		if err != nil {
+			return fmt.Errorf("Error removing encryption of log group %s: %s", logGroupName, err)
+		}
+	}
+	return putLogRetention(d, m)
+}
+
+func putLogRetention(d *schema.ResourceData, m interface{}) error {
+	logsconn := m.(*AWSClient).logsconn
+	logGroupName := functionLogGroupName(d.Get("function_name").(string))
+
+	days := d.Get("log_retention_days").(int)
+	var err error
+	if days == 0 {
+		_, err = logsconn.DeleteRetentionPolicy(&cloudwatchlogs.DeleteRetentionPolicyInput{
+			LogGroupName: aws.String(logGroupName),
+		})
+	} else {
+		_, err = logsconn.PutRetentionPolicy(&cloudwatchlogs.PutRetentionPolicyInput{
+			LogGroupName:    aws.String(logGroupName),
+			RetentionInDays: aws.Int64(int64(days)),
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("Error setting retention of log group %s: %s", logGroupName, err)
+	}
+	return nil
+}
+
+func deleteFunctionLogGroup(d *schema.ResourceData, m interface{}) error {
+	logGroupName := functionLogGroupName(d.Get("function_name").(string))
+	_, err := m.(*AWSClient).logsconn.DeleteLogGroup(&cloudwatchlogs.DeleteLogGroupInput{
+		LogGroupName: aws.String(logGroupName),
+	})
+	if err != nil && !isAWSErr(err, cloudwatchlogs.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error deleting log group %s: %s", logGroupName, err)
+	}
+	return nil
+}
+
+// expandLoggingConfig points the function at its log group in the chosen
+// format. Log levels only apply to JSON logs.
+func expandLoggingConfig(d *schema.ResourceData) *lambda.LoggingConfig {
+	config := &lambda.LoggingConfig{
+		LogFormat: aws.String(d.Get("log_format").(string)),
+		LogGroup:  aws.String(functionLogGroupName(d.Get("function_name").(string))),
+	}
+	if d.Get("log_format").(string) == lambda.LogFormatJson {
+		if v, ok := d.GetOk("application_log_level"); ok {
+			config.ApplicationLogLevel = aws.String(v.(string))
+		}
+		if v, ok := d.GetOk("system_log_level"); ok {
+			config.SystemLogLevel = aws.String(v.(string))
+		}
+	}
+	return config
+}
+
+// logGroupArn is the ARN CloudWatch Logs invokes a subscription destination
+// from
+func logGroupArn(d *schema.ResourceData) (string, error) {
+	functionArn, err := arn.Parse(d.Id())
+	if err != nil {
+		return "", fmt.Errorf("Error parsing function ARN %q: %s", d.Id(), err)
+	}
+	return arn.ARN{
+		Partition: functionArn.Partition,
+		Service:   "logs",
+		Region:    functionArn.Region,
+		AccountID: functionArn.AccountID,
+		Resource:  fmt.Sprintf("log-group:%s:*", functionLogGroupName(d.Get("function_name").(string))),
+	}.String(), nil
+}
+
+func isFirehoseArn(s string) bool {
+	parsed, err := arn.Parse(s)
+	return err == nil && parsed.Service == "firehose"
+}
+
+// putLogSubscription forwards the log group to the subscription's
+// destination, granting whatever access it needs first
+func putLogSubscription(d *schema.ResourceData, m interface{}, subscription map[string]interface{}) error {
+	functionName := d.Get("function_name").(string)
+	destinationArn := subscription["destination_id"].(string)
+	sourceArn, err := logGroupArn(d)
+	if err != nil {
+		return err
+	}
+
+	input := &cloudwatchlogs.PutSubscriptionFilterInput{
+		LogGroupName:   aws.String(functionLogGroupName(functionName)),
+		FilterName:     aws.String(logSubscriptionFilterName(functionName)),
+		FilterPattern:  aws.String(subscription["filter_pattern"].(string)),
+		DestinationArn: aws.String(destinationArn),
+	}
+
+	if isFirehoseArn(destinationArn) {
+		statements := []*iamPolicyStatement{
+			{
+				Effect:   "Allow",
+				Action:   []string{"firehose:PutRecord", "firehose:PutRecordBatch"},
+				Resource: []string{destinationArn},
+			},
+		}
+		if err := putRoleInlinePolicy(m.(*AWSClient).iamconn, logsRoleArn(d, m), logsPolicyName(functionName), statements); err != nil {
+			return err
+		}
+		input.RoleArn = aws.String(logsRoleArn(d, m))
+	} else {
+		_, err := m.(*AWSClient).lambdaconn.AddPermission(&lambda.AddPermissionInput{
+			Action:       aws.String("lambda:InvokeFunction"),
+			FunctionName: aws.String(destinationArn),
+			Principal:    aws.String("logs.amazonaws.com"),
+			StatementId:  aws.String(logSubscriptionFilterName(functionName)),
+			SourceArn:    aws.String(sourceArn),
+		})
+		if err != nil && !isAWSErr(err, lambda.ErrCodeResourceConflictException, "") {
+			return fmt.Errorf("Error adding lambda permission for log subscription: %s", err)
+		}
+	}
+
+	// CloudWatch Logs sends a test message, which fails until the access
+	// granted above has propagated
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		_, err := m.(*AWSClient).logsconn.PutSubscriptionFilter(input)
+		if isAWSErr(err, cloudwatchlogs.ErrCodeInvalidParameterException, "Could not") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error creating log subscription filter: %s", err)
+	}
+	return nil
+}
+
+// deleteLogSubscription stops forwarding the log group and revokes the
+// access granted to the subscription's destination
+func deleteLogSubscription(d *schema.ResourceData, m interface{}, subscription map[string]interface{}) error {
+	functionName := d.Get("function_name").(string)
+	destinationArn := subscription["destination_id"].(string)
+
+	_, err := m.(*AWSClient).logsconn.DeleteSubscriptionFilter(&cloudwatchlogs.DeleteSubscriptionFilterInput{
+		LogGroupName: aws.String(functionLogGroupName(functionName)),
+		FilterName:   aws.String(logSubscriptionFilterName(functionName)),
+	})
+	if err != nil && !isAWSErr(err, cloudwatchlogs.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing log subscription filter: %s", err)
+	}
+
+	if isFirehoseArn(destinationArn) {
+		return deleteRoleInlinePolicy(m.(*AWSClient).iamconn, logsRoleArn(d, m), logsPolicyName(functionName))
+	}
+	_, err = m.(*AWSClient).lambdaconn.RemovePermission(&lambda.RemovePermissionInput{
+		FunctionName: aws.String(destinationArn),
+		StatementId:  aws.String(logSubscriptionFilterName(functionName)),
+	})
+	if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing lambda permission for log subscription: %s", err)
+	}
+	return nil
+}
+
+// validateFunctionLogs checks that log levels are only set on JSON logs and
+// that subscriptions forward to a function or a Firehose stream
+func validateFunctionLogs(diff *schema.ResourceDiff) error {
+	if diff.Get("log_format").(string) != lambda.LogFormatJson {
+		for _, key := range []string{"application_log_level", "system_log_level"} {
+			if diff.Get(key).(string) != "" {
+				return fmt.Errorf("%s: requires log_format to be %q", key, lambda.LogFormatJson)
+			}
+		}
+	}
+
+	for _, s := range diff.Get("log_subscription").([]interface{}) {
+		destinationArn := s.(map[string]interface{})["destination_id"].(string)
+		if destinationArn != "" && !isFirehoseArn(destinationArn) && outputType(destinationArn) != outputTypeFunction {
+			return fmt.Errorf("log_subscription: destination %q is not the uri of a function or a Firehose stream", destinationArn)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package plausible
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// IAM changes take a while to reach Lambda, which checks that the receiving
+// function's role may read the queue when the event source mapping is created
+const iamPropagationTimeout = 2 * time.Minute
+
+// A function that outputs to another function does so through an SQS queue,
+// which buffers messages between the two. The sender is granted
+// sqs:SendMessage and finds the queue through PLAUSIBLE_QUEUE_<NAME>, and the
+// receiver is triggered from the queue by an event source mapping. Each such
+// queue is recorded in the registry as an edge from sender to receiver.
+//
+// The mapping invokes the receiver's deployment alias when it has one. Each
+// function records what its triggers invoke in the registry, and the sender
+// looks it up there, moving the mapping when the outputs are next applied.
+
+// functionOutputKey identifies an output across changes to the outputs list
+func functionOutputKey(output map[string]interface{}) string {
+	return output["name"].(string) + "|" + output["resource_id"].(string)
+}
+
+// createFunctionOutputQueue creates the queue for a function output. It runs
+// before the sending function exists, so that the queue URL can be passed to
+// it as an environment variable.
+func createFunctionOutputQueue(m interface{}, output map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	sqsconn := m.(*AWSClient).sqsconn
+	receiverArn := output["resource_id"].(string)
+
+	// Size the visibility timeout for the receiving function
+	receiver, err := conn.GetFunctionConfiguration(&lambda.GetFunctionConfigurationInput{
+		FunctionName: aws.String(receiverArn),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading receiving function %q of output %q: %s", receiverArn, output["name"], err)
+	}
+	visibilityTimeout := sqsVisibilityTimeoutFactor * int(aws.Int64Value(receiver.Timeout))
+	if visibilityTimeout > sqsMaxVisibilityTimeout {
+		visibilityTimeout = sqsMaxVisibilityTimeout
+	}
+
+	queueUrl, queueArn, err := createQueue(sqsconn, resource.UniqueId(), map[string]*string{
+		sqs.QueueAttributeNameVisibilityTimeout: aws.String(strconv.Itoa(visibilityTimeout)),
+	})
+	if err != nil {
+		return fmt.Errorf("Creating SQS queue for output %q failed: %s", output["name"], err)
+	}
+	output["queue_url"] = queueUrl
+	output["queue_arn"] = queueArn
+	output["receiver_role"] = aws.StringValue(receiver.Role)
+	return nil
+}
+
+// connectFunctionOutputQueue lets the receiving function read the queue and
+// triggers it from the queue
+func connectFunctionOutputQueue(d *schema.ResourceData, m interface{}, output map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	queueArn := output["queue_arn"].(string)
+	receiverArn := output["resource_id"].(string)
+	target, err := functionOutputReceiverTarget(m, receiverArn)
+	if err != nil {
+		return err
+	}
+
+	statements := []*iamPolicyStatement{
+		{
+			Effect: "Allow",
+			Action: []string{
+				"sqs:ReceiveMessage",
+				"sqs:DeleteMessage",
+				"sqs:GetQueueAttributes",
+			},
+			Resource: []string{queueArn},
+		},
+	}
+	err = putRoleInlinePolicy(m.(*AWSClient).iamconn, output["receiver_role"].(string), functionOutputQueuePolicyName(output), statements)
+	if err != nil {
+		return err
+	}
+
+	var mapping *lambda.EventSourceMappingConfiguration
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		var err error
+		mapping, err = conn.CreateEventSourceMapping(&lambda.CreateEventSourceMappingInput{
+			EventSourceArn: aws.String(queueArn),
+			FunctionName:   aws.String(target),
+			Enabled:        aws.Bool(true),
+		})
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "execution role does not have permissions") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Creating Lambda event source mapping for output %q failed: %s", output["name"], err)
+	}
+	output["event_source_mapping_id"] = aws.StringValue(mapping.UUID)
+	output["receiver_target"] = target
+
+	err = registryPutItem(m.(*AWSClient).AppName, &RegistryItem{
+		Id:   queueArn,
+		Type: "function_edge",
+		Attributes: map[string]string{
+			"source": d.Id(),
+			"target": receiverArn,
+			"queue":  output["queue_url"].(string),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error registering edge for output %q: %s", output["name"], err)
+	}
+	return nil
+}
+
+// retargetFunctionOutputQueue moves the event source mapping to what the
+// receiving function's triggers invoke, if that has changed
+func retargetFunctionOutputQueue(m interface{}, output map[string]interface{}) error {
+	target, err := functionOutputReceiverTarget(m, output["resource_id"].(string))
+	if err != nil {
+		return err
+	}
+	if target == output["receiver_target"].(string) {
+		return nil
+	}
+
+	_, err = m.(*AWSClient).lambdaconn.UpdateEventSourceMapping(&lambda.UpdateEventSourceMappingInput{
+		UUID:         aws.String(output["event_source_mapping_id"].(string)),
+		FunctionName: aws.String(target),
+	})
+	if err != nil {
+		return fmt.Errorf("Error moving Lambda event source mapping for output %q to %s: %s", output["name"], target, err)
+	}
+	output["receiver_target"] = target
+	return nil
+}
+
+// functionOutputReceiverTarget returns what the receiving function's triggers
+// invoke. Functions the registry does not know are invoked directly.
+func functionOutputReceiverTarget(m interface{}, receiverArn string) (string, error) {
+	item, err := registryGet(m.(*AWSClient).AppName, receiverArn)
+	if err != nil {
+		return receiverArn, nil
+	}
+	if target := item.Attributes["target"]; target != "" {
+		return target, nil
+	}
+	return receiverArn, nil
+}
+
+// registryPutFunction records what the function's triggers invoke, so that
+// functions outputting to it can trigger the same
+func registryPutFunction(appName string, d *schema.ResourceData) error {
+	return registryPutItem(appName, &RegistryItem{
+		Id:   d.Id(),
+		Type: "function",
+		Attributes: map[string]string{
+			"target": functionTargetArn(d),
+		},
+	})
+}
+
+func deleteFunctionOutputQueue(m interface{}, output map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	sqsconn := m.(*AWSClient).sqsconn
+
+	if id, ok := output["event_source_mapping_id"].(string); ok && id != "" {
+		_, err := conn.DeleteEventSourceMapping(&lambda.DeleteEventSourceMappingInput{
+			UUID: aws.String(id),
+		})
+		if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+			return fmt.Errorf("Error removing Lambda event source mapping for output %q: %s", output["name"], err)
+		}
+	}
+
+	if role, ok := output["receiver_role"].(string); ok && role != "" {
+		if err := deleteRoleInlinePolicy(m.(*AWSClient).iamconn, role, functionOutputQueuePolicyName(output)); err != nil {
+			return err
+		}
+	}
+
+	if url, ok := output["queue_url"].(string); ok && url != "" {
+		_, err := sqsconn.DeleteQueue(&sqs.DeleteQueueInput{
+			QueueUrl: aws.String(url),
+		})
+		if err != nil && !isAWSErr(err, sqs.ErrCodeQueueDoesNotExist, "") {
+			return fmt.Errorf("Error removing SQS queue for output %q: %s", output["name"], err)
+		}
+		registryDelete(m.(*AWSClient).AppName, output["queue_arn"].(string))
+	}
+	return nil
+}
+
+// createFunctionOutputQueues creates queues for the function outputs that do
+// not have one yet. Outputs carried over from the previous state keep theirs.
+func createFunctionOutputQueues(m interface{}, outputs []interface{}, previous []interface{}) error {
+	existing := map[string]map[string]interface{}{}
+	for _, o := range previous {
+		output := o.(map[string]interface{})
+		existing[functionOutputKey(output)] = output
+	}
+
+	for _, o := range outputs {
+		output := o.(map[string]interface{})
+		if outputType(output["resource_id"].(string)) != outputTypeFunction {
+			continue
+		}
+		if old, ok := existing[functionOutputKey(output)]; ok && old["queue_url"].(string) != "" {
+			for _, key := range []string{"queue_url", "queue_arn", "receiver_role", "event_source_mapping_id", "receiver_target"} {
+				output[key] = old[key]
+			}
+			continue
+		}
+		if err := createFunctionOutputQueue(m, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// connectFunctionOutputQueues wires up the queues that are not connected to
+// their receiving function yet, and moves those that are to what it invokes
+func connectFunctionOutputQueues(d *schema.ResourceData, m interface{}, outputs []interface{}) error {
+	for _, o := range outputs {
+		output := o.(map[string]interface{})
+		if outputType(output["resource_id"].(string)) != outputTypeFunction {
+			continue
+		}
+		if output["event_source_mapping_id"].(string) != "" {
+			if err := retargetFunctionOutputQueue(m, output); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := connectFunctionOutputQueue(d, m, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFunctionOutputQueues removes the queues of outputs in previous that
+// are no longer in outputs
+func deleteFunctionOutputQueues(m interface{}, outputs []interface{}, previous []interface{}) error {
+	kept := map[string]bool{}
+	for _, o := range outputs {
+		kept[functionOutputKey(o.(map[string]interface{}))] = true
+	}
+
+	for _, o := range previous {
+		output := o.(map[string]interface{})
+		if outputType(output["resource_id"].(string)) != outputTypeFunction || kept[functionOutputKey(output)] {
+			continue
+		}
+		if err := deleteFunctionOutputQueue(m, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func functionOutputQueuePolicyName(output map[string]interface{}) string {
+	queueArn := output["queue_arn"].(string)
+	return fmt.Sprintf("%s-receive", queueArn[strings.LastIndex(queueArn, ":")+1:])
+}
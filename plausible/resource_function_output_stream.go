@@ -0,0 +1,279 @@
+package plausible
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/firehose"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A buffered object store output writes through a Firehose delivery stream,
+// which batches records into objects in the bucket. The function is granted
+// firehose:PutRecordBatch and finds the stream through
+// PLAUSIBLE_STREAM_<NAME>. Firehose writes to the bucket as the existing
+// PlausibleFirehoseRole, which gets an inline policy per stream.
+
+const (
+	defaultErrorOutputPrefix      = "errors/!{firehose:error-output-type}/"
+	deliveryStreamCreationTimeout = 5 * time.Minute
+)
+
+func firehoseRoleArn(d *schema.ResourceData, m interface{}) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/PlausibleFirehoseRole", m.(*AWSClient).partition, d.Get("account_id").(string))
+}
+
+func deliveryPolicyName(streamName string) string {
+	return fmt.Sprintf("%s-delivery", streamName)
+}
+
+// isBufferedOutput reports whether an output writes through a delivery stream
+func isBufferedOutput(output map[string]interface{}) bool {
+	buffered, ok := output["buffered"].([]interface{})
+	return ok && len(buffered) > 0 && outputType(output["resource_id"].(string)) == outputTypeObject
+}
+
+// expandDeliveryDestination builds the S3 destination of a buffered output,
+// compiling its prefix against the object store's key components
+func expandDeliveryDestination(d *schema.ResourceData, m interface{}, output map[string]interface{}) (*firehose.ExtendedS3DestinationConfiguration, error) {
+	bucketArn := output["resource_id"].(string)
+	buffered := output["buffered"].([]interface{})[0].(map[string]interface{})
+
+	destination := &firehose.ExtendedS3DestinationConfiguration{
+		BucketARN: aws.String(bucketArn),
+		RoleARN:   aws.String(firehoseRoleArn(d, m)),
+		BufferingHints: &firehose.BufferingHints{
+			SizeInMBs:         aws.Int64(int64(buffered["buffering_size"].(int))),
+			IntervalInSeconds: aws.Int64(int64(buffered["buffering_interval"].(int))),
+		},
+		CompressionFormat: aws.String(buffered["compression"].(string)),
+	}
+
+	if path := buffered["prefix"].(string); path != "" {
+		parsed, err := arn.Parse(bucketArn)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing object store uri %q: %s", bucketArn, err)
+		}
+		item, err := registryGet(m.(*AWSClient).AppName, parsed.Resource)
+		if err != nil {
+			return nil, fmt.Errorf("Error looking up key components of object store %q: %s", parsed.Resource, err)
+		}
+		keyComponents, err := decodeKeyComponents(item.Attributes["key_components"])
+		if err != nil {
+			return nil, err
+		}
+		prefix, err := compileKeyPrefix(path, keyComponents)
+		if err != nil {
+			return nil, fmt.Errorf("Error compiling prefix of output %q: %s", output["name"], err)
+		}
+		destination.Prefix = aws.String(prefix)
+	}
+
+	errorOutputPrefix := buffered["error_output_prefix"].(string)
+	if errorOutputPrefix == "" && strings.Contains(aws.StringValue(destination.Prefix), "!{") {
+		// Firehose requires an error prefix alongside an expression prefix
+		errorOutputPrefix = defaultErrorOutputPrefix
+	}
+	if errorOutputPrefix != "" {
+		destination.ErrorOutputPrefix = aws.String(errorOutputPrefix)
+	}
+	return destination, nil
+}
+
+// putDeliveryPolicy lets Firehose write to the output's bucket
+func putDeliveryPolicy(d *schema.ResourceData, m interface{}, streamName string, bucketArn string) error {
+	statements := []*iamPolicyStatement{
+		{
+			Effect: "Allow",
+			Action: []string{
+				"s3:AbortMultipartUpload",
+				"s3:GetBucketLocation",
+				"s3:GetObject",
+				"s3:ListBucket",
+				"s3:ListBucketMultipartUploads",
+				"s3:PutObject",
+			},
+			Resource: []string{bucketArn, bucketArn + "/*"},
+		},
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, firehoseRoleArn(d, m), deliveryPolicyName(streamName), statements)
+}
+
+func createFunctionOutputStream(d *schema.ResourceData, m interface{}, output map[string]interface{}) error {
+	firehoseconn := m.(*AWSClient).firehoseconn
+	streamName := resource.UniqueId()
+
+	destination, err := expandDeliveryDestination(d, m, output)
+	if err != nil {
+		return err
+	}
+	if err := putDeliveryPolicy(d, m, streamName, output["resource_id"].(string)); err != nil {
+		return err
+	}
+
+	input := &firehose.CreateDeliveryStreamInput{
+		DeliveryStreamName:                 aws.String(streamName),
+		DeliveryStreamType:                 aws.String(firehose.DeliveryStreamTypeDirectPut),
+		ExtendedS3DestinationConfiguration: destination,
+	}
+	log.Printf("[DEBUG] Creating Firehose delivery stream: %s", input)
+	var out *firehose.CreateDeliveryStreamOutput
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		var err error
+		out, err = firehoseconn.CreateDeliveryStream(input)
+		if isAWSErr(err, firehose.ErrCodeInvalidArgumentException, "role") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Creating Firehose delivery stream for output %q failed: %s", output["name"], err)
+	}
+
+	err = resource.Retry(deliveryStreamCreationTimeout, func() *resource.RetryError {
+		described, err := firehoseconn.DescribeDeliveryStream(&firehose.DescribeDeliveryStreamInput{
+			DeliveryStreamName: aws.String(streamName),
+		})
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		status := aws.StringValue(described.DeliveryStreamDescription.DeliveryStreamStatus)
+		if status == firehose.DeliveryStreamStatusCreating {
+			return resource.RetryableError(fmt.Errorf("delivery stream %s is still being created", streamName))
+		}
+		if status != firehose.DeliveryStreamStatusActive {
+			return resource.NonRetryableError(fmt.Errorf("delivery stream %s is %s", streamName, status))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for Firehose delivery stream %s to become active: %s", streamName, err)
+	}
+
+	output["delivery_stream_name"] = streamName
+	output["delivery_stream_arn"] = aws.StringValue(out.DeliveryStreamARN)
+	return nil
+}
+
+// updateFunctionOutputStream applies a changed buffered block to the
+// output's existing delivery stream
+func updateFunctionOutputStream(d *schema.ResourceData, m interface{}, output map[string]interface{}) error {
+	firehoseconn := m.(*AWSClient).firehoseconn
+	streamName := output["delivery_stream_name"].(string)
+
+	destination, err := expandDeliveryDestination(d, m, output)
+	if err != nil {
+		return err
+	}
+	described, err := firehoseconn.DescribeDeliveryStream(&firehose.DescribeDeliveryStreamInput{
+		DeliveryStreamName: aws.String(streamName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error reading Firehose delivery stream %s: %s", streamName, err)
+	}
+	stream := described.DeliveryStreamDescription
+	if len(stream.Destinations) == 0 {
+		return fmt.Errorf("Firehose delivery stream %s has no destination", streamName)
+	}
+
+	_, err = firehoseconn.UpdateDestination(&firehose.UpdateDestinationInput{
+		DeliveryStreamName:             aws.String(streamName),
+		CurrentDeliveryStreamVersionId: stream.VersionId,
+		DestinationId:                  stream.Destinations[0].DestinationId,
+		ExtendedS3DestinationUpdate: &firehose.ExtendedS3DestinationUpdate{
+			BucketARN:         destination.BucketARN,
+			RoleARN:           destination.RoleARN,
+			BufferingHints:    destination.BufferingHints,
+			CompressionFormat: destination.CompressionFormat,
+			Prefix:            aws.String(aws.StringValue(destination.Prefix)),
+			ErrorOutputPrefix: aws.String(aws.StringValue(destination.ErrorOutputPrefix)),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Error updating Firehose delivery stream %s: %s", streamName, err)
+	}
+	return nil
+}
+
+func deleteFunctionOutputStream(d *schema.ResourceData, m interface{}, output map[string]interface{}) error {
+	firehoseconn := m.(*AWSClient).firehoseconn
+	streamName, ok := output["delivery_stream_name"].(string)
+	if !ok || streamName == "" {
+		return nil
+	}
+
+	_, err := firehoseconn.DeleteDeliveryStream(&firehose.DeleteDeliveryStreamInput{
+		DeliveryStreamName: aws.String(streamName),
+	})
+	if err != nil && !isAWSErr(err, firehose.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing Firehose delivery stream for output %q: %s", output["name"], err)
+	}
+	return deleteRoleInlinePolicy(m.(*AWSClient).iamconn, firehoseRoleArn(d, m), deliveryPolicyName(streamName))
+}
+
+// createFunctionOutputStreams creates delivery streams for buffered outputs
+// that do not have one yet, and updates those whose buffered block changed
+func createFunctionOutputStreams(d *schema.ResourceData, m interface{}, outputs []interface{}, previous []interface{}) error {
+	existing := map[string]map[string]interface{}{}
+	for _, o := range previous {
+		output := o.(map[string]interface{})
+		if isBufferedOutput(output) {
+			existing[functionOutputKey(output)] = output
+		}
+	}
+
+	for _, o := range outputs {
+		output := o.(map[string]interface{})
+		if !isBufferedOutput(output) {
+			continue
+		}
+		old, ok := existing[functionOutputKey(output)]
+		if !ok || old["delivery_stream_name"].(string) == "" {
+			if err := createFunctionOutputStream(d, m, output); err != nil {
+				return err
+			}
+			continue
+		}
+
+		output["delivery_stream_name"] = old["delivery_stream_name"]
+		output["delivery_stream_arn"] = old["delivery_stream_arn"]
+		if !reflect.DeepEqual(old["buffered"], output["buffered"]) {
+			if err := updateFunctionOutputStream(d, m, output); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deleteFunctionOutputStreams removes the delivery streams of buffered
+// outputs in previous that are no longer buffered outputs in outputs
+func deleteFunctionOutputStreams(d *schema.ResourceData, m interface{}, outputs []interface{}, previous []interface{}) error {
+	kept := map[string]bool{}
+	for _, o := range outputs {
+		output := o.(map[string]interface{})
+		if isBufferedOutput(output) {
+			kept[functionOutputKey(output)] = true
+		}
+	}
+
+	for _, o := range previous {
+		output := o.(map[string]interface{})
+		if !isBufferedOutput(output) || kept[functionOutputKey(output)] {
+			continue
+		}
+		if err := deleteFunctionOutputStream(d, m, output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
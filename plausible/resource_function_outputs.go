@@ -0,0 +1,212 @@
+package plausible
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	outputTypeKeyValue  = "keyvalue"
+	outputTypeObject    = "object"
+	outputTypePublisher = "publisher"
+	outputTypeFunction  = "function"
+)
+
+var outputNameRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// bufferedOutputEnvPrefix names the delivery stream of a buffered object
+// store output
+const bufferedOutputEnvPrefix = "PLAUSIBLE_STREAM_"
+
+// outputEnvPrefixes are the environment variable prefixes through which a
+// function finds each kind of output
+var outputEnvPrefixes = map[string]string{
+	outputTypeKeyValue:  "PLAUSIBLE_KV_",
+	outputTypeObject:    "PLAUSIBLE_BUCKET_",
+	outputTypePublisher: "PLAUSIBLE_TOPIC_",
+	outputTypeFunction:  "PLAUSIBLE_QUEUE_",
+}
+
+// outputType determines the kind of Plausible resource an output writes to
+// from its id, which is the uri of the resource
+func outputType(resourceId string) string {
+	lower := strings.ToLower(resourceId)
+	switch {
+	case strings.Contains(lower, ":dynamodb"):
+		return outputTypeKeyValue
+	case strings.Contains(lower, ":s3"):
+		return outputTypeObject
+	case strings.Contains(lower, ":sns"):
+		return outputTypePublisher
+	case strings.Contains(lower, ":lambda"):
+		return outputTypeFunction
+	}
+	return ""
+}
+
+func outputsPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-outputs", functionName)
+}
+
+func validateOutputName(val interface{}, key string) (warns []string, errs []error) {
+	if !outputNameRegexp.MatchString(val.(string)) {
+		errs = append(errs, fmt.Errorf("%q must start with a letter and contain only letters, digits and underscores, not %q", key, val.(string)))
+	}
+	return
+}
+
+// expandFunctionEnvironment merges the literal environment variables with
+// the secret variables and those that point the function at its outputs
+func expandFunctionEnvironment(d *schema.ResourceData, m interface{}) (*lambda.Environment, error) {
+	variables := map[string]*string{}
+	if v, ok := d.GetOk("environment"); ok && len(v.([]interface{})) > 0 && v.([]interface{})[0] != nil {
+		env := v.([]interface{})[0].(map[string]interface{})
+		for name, value := range env["variables"].(map[string]interface{}) {
+			variables[name] = aws.String(value.(string))
+		}
+	}
+
+	outputVariables, err := functionOutputVariables(d.Get("outputs").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range outputVariables {
+		if _, ok := variables[name]; ok {
+			return nil, fmt.Errorf("environment variable %s is set both in environment and by outputs", name)
+		}
+		variables[name] = aws.String(value)
+	}
+
+	secretVariables, err := functionSecretVariables(d, m)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range secretVariables {
+		if _, ok := variables[name]; ok {
+			return nil, fmt.Errorf("environment variable %s is set both by secret_variables and elsewhere", name)
+		}
+		variables[name] = aws.String(value)
+	}
+
+	return &lambda.Environment{Variables: variables}, nil
+}
+
+// functionOutputVariables returns the environment variables that name each
+// output, such as PLAUSIBLE_KV_ORDERS for the table behind an output "orders"
+func functionOutputVariables(outputs []interface{}) (map[string]string, error) {
+	variables := map[string]string{}
+	for _, o := range outputs {
+		output := o.(map[string]interface{})
+		resourceId := output["resource_id"].(string)
+		_type := outputType(resourceId)
+		parsed, err := arn.Parse(resourceId)
+		if err != nil || _type == "" {
+			return nil, fmt.Errorf("output %q has resource_id %q, which is not the uri of a key-value store, object store, publisher or function", output["name"], resourceId)
+		}
+
+		name := outputEnvPrefixes[_type] + strings.ToUpper(output["name"].(string))
+		if isBufferedOutput(output) {
+			variables[bufferedOutputEnvPrefix+strings.ToUpper(output["name"].(string))] = output["delivery_stream_name"].(string)
+			continue
+		}
+		switch _type {
+		case outputTypeKeyValue:
+			variables[name] = strings.TrimPrefix(parsed.Resource, "table/")
+		case outputTypeObject:
+			variables[name] = parsed.Resource
+		case outputTypePublisher:
+			variables[name] = resourceId
+		case outputTypeFunction:
+			variables[name] = output["queue_url"].(string)
+		}
+	}
+	return variables, nil
+}
+
+// functionOutputStatements grants the function write access to each of its
+// outputs, and nothing more
+func functionOutputStatements(outputs []interface{}) ([]*iamPolicyStatement, error) {
+	statements := []*iamPolicyStatement{}
+	for _, o := range outputs {
+		output := o.(map[string]interface{})
+		resourceId := output["resource_id"].(string)
+
+		var statement *iamPolicyStatement
+		switch outputType(resourceId) {
+		case outputTypeKeyValue:
+			statement = &iamPolicyStatement{
+				Action: []string{
+					"dynamodb:PutItem",
+					"dynamodb:UpdateItem",
+					"dynamodb:DeleteItem",
+					"dynamodb:BatchWriteItem",
+				},
+				Resource: []string{resourceId},
+			}
+		case outputTypeObject:
+			statement = &iamPolicyStatement{
+				Action:   []string{"s3:PutObject"},
+				Resource: []string{resourceId + "/*"},
+			}
+			if isBufferedOutput(output) {
+				statement = &iamPolicyStatement{
+					Action: []string{
+						"firehose:PutRecord",
+						"firehose:PutRecordBatch",
+					},
+					Resource: []string{output["delivery_stream_arn"].(string)},
+				}
+			}
+		case outputTypePublisher:
+			statement = &iamPolicyStatement{
+				Action:   []string{"sns:Publish"},
+				Resource: []string{resourceId},
+			}
+		case outputTypeFunction:
+			statement = &iamPolicyStatement{
+				Action:   []string{"sqs:SendMessage"},
+				Resource: []string{output["queue_arn"].(string)},
+			}
+		default:
+			return nil, fmt.Errorf("output %q has resource_id %q, which is not the uri of a key-value store, object store, publisher or function", output["name"], resourceId)
+		}
+		statement.Effect = "Allow"
+		statements = append(statements, statement)
+	}
+	return statements, nil
+}
+
+// putFunctionOutputs writes the inline policy that grants the function access
+// to its outputs
+func putFunctionOutputs(d *schema.ResourceData, m interface{}, roleArn string) error {
+	statements, err := functionOutputStatements(d.Get("outputs").([]interface{}))
+	if err != nil {
+		return err
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, roleArn, outputsPolicyName(d.Get("function_name").(string)), statements)
+}
+
+// validateFunctionOutputs checks that no two outputs would set the same
+// environment variable, and that only object store outputs are buffered
+func validateFunctionOutputs(diff *schema.ResourceDiff) error {
+	names := map[string]bool{}
+	for _, o := range diff.Get("outputs").([]interface{}) {
+		output := o.(map[string]interface{})
+		buffered, ok := output["buffered"].([]interface{})
+		if ok && len(buffered) > 0 && outputType(output["resource_id"].(string)) != outputTypeObject {
+			return fmt.Errorf("outputs: output %q is buffered, which is only supported for object stores", output["name"])
+		}
+		name := strings.ToUpper(output["name"].(string))
+		if names[name] {
+			return fmt.Errorf("outputs: more than one output is named %q", output["name"])
+		}
+		names[name] = true
+	}
+	return nil
+}
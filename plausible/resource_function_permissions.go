@@ -0,0 +1,377 @@
+package plausible
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Each function runs as a role of its own, named after the function. The role
+// starts out with the AWS managed policies that let Lambda write logs and
+// poll the queues and streams that trigger it. Everything else is granted by
+// inline policies: one generated from the permissions blocks and the raw
+// policy_statement blocks, and one for the function's outputs.
+
+var functionRoleManagedPolicyNames = []string{
+	"AWSLambdaBasicExecutionRole",
+	"AWSLambdaSQSQueueExecutionRole",
+	"AWSLambdaDynamoDBExecutionRole",
+}
+
+// functionRoleManagedPolicies returns the ARNs of the managed policies in the
+// provider's partition
+func functionRoleManagedPolicies(m interface{}) []string {
+	arns := make([]string, 0, len(functionRoleManagedPolicyNames))
+	for _, name := range functionRoleManagedPolicyNames {
+		arns = append(arns, fmt.Sprintf("arn:%s:iam::aws:policy/service-role/%s", m.(*AWSClient).partition, name))
+	}
+	return arns
+}
+
+const (
+	accessRead    = "read"
+	accessWrite   = "write"
+	accessPublish = "publish"
+	accessInvoke  = "invoke"
+)
+
+// permissionActions lists the actions granted for each access level on each
+// kind of Plausible resource. Access levels missing here are not supported.
+var permissionActions = map[string]map[string][]string{
+	outputTypeKeyValue: {
+		accessRead: {
+			"dynamodb:GetItem",
+			"dynamodb:BatchGetItem",
+			"dynamodb:Query",
+			"dynamodb:Scan",
+			"dynamodb:DescribeTable",
+		},
+		accessWrite: {
+			"dynamodb:PutItem",
+			"dynamodb:UpdateItem",
+			"dynamodb:DeleteItem",
+			"dynamodb:BatchWriteItem",
+		},
+	},
+	outputTypeObject: {
+		accessRead: {
+			"s3:GetObject",
+			"s3:ListBucket",
+		},
+		accessWrite: {
+			"s3:PutObject",
+			"s3:DeleteObject",
+		},
+	},
+	outputTypePublisher: {
+		accessPublish: {
+			"sns:Publish",
+		},
+	},
+	outputTypeFunction: {
+		accessInvoke: {
+			"lambda:InvokeFunction",
+		},
+	},
+}
+
+func functionRoleName(functionName string) string {
+	return fmt.Sprintf("%s-role", functionName)
+}
+
+func permissionsPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-permissions", functionName)
+}
+
+// createFunctionRole creates the role the function runs as and returns its ARN
+func createFunctionRole(m interface{}, functionName string) (string, error) {
+	iamconn := m.(*AWSClient).iamconn
+	roleName := functionRoleName(functionName)
+
+	trust, err := newIamPolicyDocument([]*iamPolicyStatement{
+		{
+			Effect:    "Allow",
+			Action:    []string{"sts:AssumeRole"},
+			Principal: map[string]string{"Service": "lambda.amazonaws.com"},
+		},
+	}).String()
+	if err != nil {
+		return "", err
+	}
+
+	log.Printf("[DEBUG] Creating IAM role %s", roleName)
+	out, err := iamconn.CreateRole(&iam.CreateRoleInput{
+		RoleName:                 aws.String(roleName),
+		AssumeRolePolicyDocument: aws.String(trust),
+		Description:              aws.String(fmt.Sprintf("Execution role of Plausible function %s", functionName)),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error creating IAM role %s: %s", roleName, err)
+	}
+
+	for _, policyArn := range functionRoleManagedPolicies(m) {
+		_, err := iamconn.AttachRolePolicy(&iam.AttachRolePolicyInput{
+			RoleName:  aws.String(roleName),
+			PolicyArn: aws.String(policyArn),
+		})
+		if err != nil {
+			return "", fmt.Errorf("Error attaching %s to IAM role %s: %s", policyArn, roleName, err)
+		}
+	}
+	return aws.StringValue(out.Role.Arn), nil
+}
+
+// functionPolicyNames lists the inline policies on a function's role that
+// grant the function its access
+func functionPolicyNames(functionName string) []string {
+	return []string{
+		outputsPolicyName(functionName),
+		permissionsPolicyName(functionName),
+		tracingPolicyName(functionName),
+		secretsPolicyName(functionName),
+		vpcPolicyName(functionName),
+		destinationsPolicyName(functionName),
+		datastorePolicyName(functionName),
+	}
+}
+
+// hasFunctionRole reports whether the function runs as a role of its own,
+// rather than the PlausibleLambdaRole shared by functions created before they
+// had one
+func hasFunctionRole(d *schema.ResourceData) bool {
+	roleName, err := roleNameFromArn(d.Get("role").(string))
+	return err == nil && roleName == functionRoleName(d.Get("function_name").(string))
+}
+
+// moveToFunctionRole moves a function running as the shared role to a role of
+// its own, since anything granted on the shared role is granted to every
+// function using it. The function's policies are copied to the new role and
+// only removed from the shared role once the function has switched over.
+func moveToFunctionRole(d *schema.ResourceData, m interface{}) error {
+	if hasFunctionRole(d) {
+		return nil
+	}
+	iamconn := m.(*AWSClient).iamconn
+	functionName := d.Get("function_name").(string)
+	roleName := functionRoleName(functionName)
+	sharedRoleArn := d.Get("role").(string)
+	sharedRoleName, err := roleNameFromArn(sharedRoleArn)
+	if err != nil {
+		return err
+	}
+
+	// An earlier attempt may have created the role already
+	var roleArn string
+	role, err := iamconn.GetRole(&iam.GetRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	switch {
+	case isAWSErr(err, iam.ErrCodeNoSuchEntityException, ""):
+		if roleArn, err = createFunctionRole(m, functionName); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("Error reading IAM role %s: %s", roleName, err)
+	default:
+		roleArn = aws.StringValue(role.Role.Arn)
+	}
+
+	for _, policyName := range functionPolicyNames(functionName) {
+		policy, err := iamconn.GetRolePolicy(&iam.GetRolePolicyInput{
+			RoleName:   aws.String(sharedRoleName),
+			PolicyName: aws.String(policyName),
+		})
+		if isAWSErr(err, iam.ErrCodeNoSuchEntityException, "") {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading IAM role policy %s: %s", policyName, err)
+		}
+		// IAM returns policy documents URL encoded
+		document, err := url.QueryUnescape(aws.StringValue(policy.PolicyDocument))
+		if err != nil {
+			return fmt.Errorf("Error decoding IAM role policy %s: %s", policyName, err)
+		}
+		_, err = iamconn.PutRolePolicy(&iam.PutRolePolicyInput{
+			RoleName:       aws.String(roleName),
+			PolicyName:     aws.String(policyName),
+			PolicyDocument: aws.String(document),
+		})
+		if err != nil {
+			return fmt.Errorf("Error putting IAM role policy %s: %s", policyName, err)
+		}
+	}
+
+	// A new role takes a while to become assumable by Lambda
+	log.Printf("[DEBUG] Moving function %s to IAM role %s", functionName, roleName)
+	err = resource.Retry(iamPropagationTimeout, func() *resource.RetryError {
+		_, err := m.(*AWSClient).lambdaconn.UpdateFunctionConfiguration(&lambda.UpdateFunctionConfigurationInput{
+			FunctionName: aws.String(functionName),
+			Role:         aws.String(roleArn),
+		})
+		if isAWSErr(err, lambda.ErrCodeInvalidParameterValueException, "cannot be assumed") {
+			return resource.RetryableError(err)
+		}
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Error moving function %s to IAM role %s: %s", functionName, roleName, err)
+	}
+	if err := waitForFunctionUpdated(m, functionName); err != nil {
+		return err
+	}
+	d.Set("role", roleArn)
+
+	for _, policyName := range functionPolicyNames(functionName) {
+		if err := deleteRoleInlinePolicy(iamconn, sharedRoleArn, policyName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteFunctionRole removes the function's role along with its policies
+func deleteFunctionRole(m interface{}, roleArn string) error {
+	iamconn := m.(*AWSClient).iamconn
+	roleName, err := roleNameFromArn(roleArn)
+	if err != nil {
+		return err
+	}
+
+	inline, err := iamconn.ListRolePolicies(&iam.ListRolePoliciesInput{
+		RoleName: aws.String(roleName),
+	})
+	if isAWSErr(err, iam.ErrCodeNoSuchEntityException, "") {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error listing policies of IAM role %s: %s", roleName, err)
+	}
+	for _, policyName := range inline.PolicyNames {
+		if err := deleteRoleInlinePolicy(iamconn, roleArn, aws.StringValue(policyName)); err != nil {
+			return err
+		}
+	}
+
+	for _, policyArn := range functionRoleManagedPolicies(m) {
+		_, err := iamconn.DetachRolePolicy(&iam.DetachRolePolicyInput{
+			RoleName:  aws.String(roleName),
+			PolicyArn: aws.String(policyArn),
+		})
+		if err != nil && !isAWSErr(err, iam.ErrCodeNoSuchEntityException, "") {
+			return fmt.Errorf("Error detaching %s from IAM role %s: %s", policyArn, roleName, err)
+		}
+	}
+
+	_, err = iamconn.DeleteRole(&iam.DeleteRoleInput{
+		RoleName: aws.String(roleName),
+	})
+	if err != nil && !isAWSErr(err, iam.ErrCodeNoSuchEntityException, "") {
+		return fmt.Errorf("Error deleting IAM role %s: %s", roleName, err)
+	}
+	return nil
+}
+
+// permissionResources returns the ARNs that a permission's actions apply to,
+// which for tables and buckets include their indexes and objects
+func permissionResources(resourceId string, access string) []string {
+	switch outputType(resourceId) {
+	case outputTypeKeyValue:
+		if access == accessRead {
+			return []string{resourceId, resourceId + "/index/*"}
+		}
+	case outputTypeObject:
+		if access == accessRead {
+			return []string{resourceId, resourceId + "/*"}
+		}
+		return []string{resourceId + "/*"}
+	}
+	return []string{resourceId}
+}
+
+// functionPermissionStatements generates the statements for the permissions
+// blocks and appends the raw policy_statement blocks
+func functionPermissionStatements(d *schema.ResourceData) ([]*iamPolicyStatement, error) {
+	statements := []*iamPolicyStatement{}
+	for _, p := range d.Get("permissions").([]interface{}) {
+		permission := p.(map[string]interface{})
+		resourceId := permission["resource_id"].(string)
+		access := permission["access"].(string)
+
+		actions, ok := permissionActions[outputType(resourceId)][access]
+		if !ok {
+			return nil, fmt.Errorf("permissions: %q access is not supported on %q", access, resourceId)
+		}
+		statements = append(statements, &iamPolicyStatement{
+			Effect:   "Allow",
+			Action:   actions,
+			Resource: permissionResources(resourceId, access),
+		})
+	}
+
+	for _, s := range d.Get("policy_statement").([]interface{}) {
+		statement := s.(map[string]interface{})
+		statements = append(statements, &iamPolicyStatement{
+			Effect:   statement["effect"].(string),
+			Action:   expandStringSet(statement["actions"].(*schema.Set)),
+			Resource: expandStringSet(statement["resources"].(*schema.Set)),
+		})
+	}
+	return statements, nil
+}
+
+// putFunctionPermissions writes the inline policy generated from the
+// function's permissions
+func putFunctionPermissions(d *schema.ResourceData, m interface{}, roleArn string) error {
+	statements, err := functionPermissionStatements(d)
+	if err != nil {
+		return err
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, roleArn, permissionsPolicyName(d.Get("function_name").(string)), statements)
+}
+
+// validateFunctionPermissions checks that each permission names an access
+// level that its kind of resource supports
+func validateFunctionPermissions(diff *schema.ResourceDiff) error {
+	for _, p := range diff.Get("permissions").([]interface{}) {
+		permission := p.(map[string]interface{})
+		resourceId := permission["resource_id"].(string)
+		if resourceId == "" {
+			// Not known until apply
+			continue
+		}
+		_type := outputType(resourceId)
+		if _type == "" {
+			return fmt.Errorf("permissions: %q is not the uri of a key-value store, object store, publisher or function", resourceId)
+		}
+		access := permission["access"].(string)
+		if _, ok := permissionActions[_type][access]; !ok {
+			supported := []string{}
+			for a := range permissionActions[_type] {
+				supported = append(supported, a)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("permissions: %q access is not supported on %q, which supports %s", access, resourceId, strings.Join(supported, ", "))
+		}
+	}
+	return nil
+}
+
+func expandStringSet(set *schema.Set) []string {
+	values := []string{}
+	for _, v := range set.List() {
+		values = append(values, v.(string))
+	}
+	return values
+}
@@ -0,0 +1,405 @@
+package plausible
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	events "github.com/aws/aws-sdk-go/service/cloudwatchevents"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/scheduler"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	// scheduleTargetId identifies the function within its schedule rule's targets
+	scheduleTargetId = "PlausibleFunction"
+
+	scheduleBackendRule      = "rule"
+	scheduleBackendScheduler = "scheduler"
+)
+
+// A schedule trigger is backed either by a CloudWatch Events rule, which only
+// supports UTC rate() and cron() expressions, or by an EventBridge Scheduler
+// schedule, which adds time zones, one-time at() expressions, start and end
+// dates and flexible time windows.
+func createScheduleTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+
+	if triggerInfo["backend"].(string) == scheduleBackendScheduler {
+		schedulerconn := m.(*AWSClient).schedulerconn
+		scheduleName := resource.UniqueId()
+		input, err := expandScheduleInput(functionTargetArn(d), scheduleName, triggerInfo)
+		if err != nil {
+			return err
+		}
+		scheduleOut, err := schedulerconn.CreateSchedule(input)
+		if err != nil {
+			return fmt.Errorf("Creating EventBridge Schedule failed: %s", err)
+		}
+		triggerInfo["schedule_id"] = aws.StringValue(scheduleOut.ScheduleArn)
+		triggerInfo["schedule_name"] = scheduleName
+		return putScheduleInvocations(triggerInfo)
+	}
+
+	// A rule-based trigger requires a CloudWatch rule that contains the schedule,
+	// a Lambda permission that allows this rule to invoke the Lambda, and
+	// an Event Target that tells the rule to invoke the Lambda
+	cwconn := m.(*AWSClient).cloudwatcheventsconn
+	ruleName := resource.UniqueId()
+	ruleOut, err := putScheduleRule(cwconn, ruleName, triggerInfo)
+	if err != nil {
+		return fmt.Errorf("Creating CloudWatch Event Rule failed: %s", err)
+	}
+	triggerInfo["schedule_id"] = aws.StringValue(ruleOut.RuleArn)
+	triggerInfo["schedule_name"] = ruleName
+
+	// Create Lambda permission. The statement is named after the rule so that
+	// it can be found again on delete
+	input := lambda.AddPermissionInput{
+		Action:       aws.String("lambda:InvokeFunction"),
+		FunctionName: aws.String(functionTargetArn(d)),
+		Principal:    aws.String("events.amazonaws.com"),
+		StatementId:  aws.String(ruleName),
+		SourceArn:    ruleOut.RuleArn,
+	}
+	_, err = conn.AddPermission(&input)
+	if err != nil {
+		return fmt.Errorf("Error adding lambda permission %+v", err)
+	}
+
+	// Create Cloudwatch event target
+	err = putScheduleTarget(cwconn, ruleName, functionTargetArn(d), triggerInfo)
+	if err != nil {
+		return fmt.Errorf("Creating CloudWatch Event Target failed: %s", err)
+	}
+	return putScheduleInvocations(triggerInfo)
+}
+
+// updateScheduleTrigger applies changes to an existing schedule trigger in
+// place. Rules and schedules keep their names, so permissions still apply.
+// Moving between backends replaces the trigger.
+func updateScheduleTrigger(d *schema.ResourceData, m interface{}, oldInfo, triggerInfo map[string]interface{}) error {
+	if oldInfo["backend"].(string) != triggerInfo["backend"].(string) {
+		if err := deleteScheduleTrigger(d, m, oldInfo); err != nil {
+			return err
+		}
+		return createScheduleTrigger(d, m, triggerInfo)
+	}
+
+	name := oldInfo["schedule_name"].(string)
+	triggerInfo["schedule_id"] = oldInfo["schedule_id"]
+	triggerInfo["schedule_name"] = name
+
+	if triggerInfo["backend"].(string) == scheduleBackendScheduler {
+		schedulerconn := m.(*AWSClient).schedulerconn
+		input, err := expandScheduleInput(functionTargetArn(d), name, triggerInfo)
+		if err != nil {
+			return err
+		}
+		_, err = schedulerconn.UpdateSchedule(&scheduler.UpdateScheduleInput{
+			Name:                       input.Name,
+			ScheduleExpression:         input.ScheduleExpression,
+			ScheduleExpressionTimezone: input.ScheduleExpressionTimezone,
+			StartDate:                  input.StartDate,
+			EndDate:                    input.EndDate,
+			FlexibleTimeWindow:         input.FlexibleTimeWindow,
+			State:                      input.State,
+			Target:                     input.Target,
+		})
+		if err != nil {
+			return fmt.Errorf("Updating EventBridge Schedule failed: %s", err)
+		}
+		return putScheduleInvocations(triggerInfo)
+	}
+
+	cwconn := m.(*AWSClient).cloudwatcheventsconn
+	if _, err := putScheduleRule(cwconn, name, triggerInfo); err != nil {
+		return fmt.Errorf("Updating CloudWatch Event Rule failed: %s", err)
+	}
+	if err := putScheduleTarget(cwconn, name, functionTargetArn(d), triggerInfo); err != nil {
+		return fmt.Errorf("Updating CloudWatch Event Target failed: %s", err)
+	}
+	return putScheduleInvocations(triggerInfo)
+}
+
+// readScheduleTrigger refreshes the expression and state of the trigger. The
+// invocations after apply are only computed again when the schedule has
+// changed outside Terraform, so that they do not change on every plan.
+func readScheduleTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	name := triggerInfo["schedule_name"].(string)
+	previous := fmt.Sprint(triggerInfo["cron"], triggerInfo["timezone"], triggerInfo["enabled"])
+
+	if triggerInfo["backend"].(string) == scheduleBackendScheduler {
+		schedulerconn := m.(*AWSClient).schedulerconn
+		schedule, err := schedulerconn.GetSchedule(&scheduler.GetScheduleInput{
+			Name: aws.String(name),
+		})
+		if err != nil {
+			return fmt.Errorf("Error reading EventBridge Schedule: %s", err)
+		}
+		triggerInfo["cron"] = aws.StringValue(schedule.ScheduleExpression)
+		// Scheduler reports UTC for schedules created without a time zone
+		if triggerInfo["timezone"].(string) != "" {
+			triggerInfo["timezone"] = aws.StringValue(schedule.ScheduleExpressionTimezone)
+		}
+		triggerInfo["enabled"] = aws.StringValue(schedule.State) == scheduler.ScheduleStateEnabled
+	} else {
+		cwconn := m.(*AWSClient).cloudwatcheventsconn
+		rule, err := cwconn.DescribeRule(&events.DescribeRuleInput{
+			Name: aws.String(name),
+		})
+		if err != nil {
+			return fmt.Errorf("Error reading CloudWatch Event Rule: %s", err)
+		}
+		triggerInfo["cron"] = aws.StringValue(rule.ScheduleExpression)
+		triggerInfo["enabled"] = aws.StringValue(rule.State) == events.RuleStateEnabled
+	}
+
+	if fmt.Sprint(triggerInfo["cron"], triggerInfo["timezone"], triggerInfo["enabled"]) != previous {
+		return putScheduleInvocations(triggerInfo)
+	}
+	return nil
+}
+
+// putScheduleInvocations lists the trigger's invocations from now, as it is
+// applied
+func putScheduleInvocations(triggerInfo map[string]interface{}) error {
+	invocations := []interface{}{}
+	if triggerInfo["enabled"].(bool) {
+		times, err := scheduleTriggerInvocations(triggerInfo, time.Now())
+		if err != nil {
+			return fmt.Errorf("Error computing invocations after apply: %s", err)
+		}
+		for _, t := range times {
+			invocations = append(invocations, t.Format(time.RFC3339))
+		}
+	}
+	triggerInfo["invocations_after_apply"] = invocations
+	return nil
+}
+
+func deleteScheduleTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	name := triggerInfo["schedule_name"].(string)
+
+	if triggerInfo["backend"].(string) == scheduleBackendScheduler {
+		// The schedule holds its own target, and invokes the function through
+		// a role rather than a Lambda permission
+		schedulerconn := m.(*AWSClient).schedulerconn
+		_, err := schedulerconn.DeleteSchedule(&scheduler.DeleteScheduleInput{
+			Name: aws.String(name),
+		})
+		if err != nil && !isAWSErr(err, scheduler.ErrCodeResourceNotFoundException, "") {
+			return fmt.Errorf("Error removing EventBridge Schedule: %s", err)
+		}
+		return nil
+	}
+
+	conn := m.(*AWSClient).lambdaconn
+	cwconn := m.(*AWSClient).cloudwatcheventsconn
+
+	// Delete CloudWatch event target
+	_, err := cwconn.RemoveTargets(&events.RemoveTargetsInput{
+		Ids:  []*string{aws.String(scheduleTargetId)},
+		Rule: aws.String(name),
+	})
+	if err != nil {
+		return fmt.Errorf("Error removing scheduling target: %s", err)
+	}
+
+	// Delete Lambda permission
+	_, err = conn.RemovePermission(&lambda.RemovePermissionInput{
+		FunctionName: aws.String(functionTargetArn(d)),
+		StatementId:  aws.String(name),
+	})
+	if err != nil {
+		return fmt.Errorf("Error removing Lambda permission: %s", err)
+	}
+
+	// Delete CloudWatch event rule
+	_, err = cwconn.DeleteRule(&events.DeleteRuleInput{
+		Name: aws.String(name),
+	})
+	if err != nil {
+		return fmt.Errorf("Error removing scheduling rule: %s", err)
+	}
+	return nil
+}
+
+// putScheduleRule creates or updates the CloudWatch rule for a schedule trigger.
+// A disabled trigger keeps its rule, target and permission, so that it can be
+// resumed later without being recreated.
+func putScheduleRule(cwconn *events.CloudWatchEvents, ruleName string, triggerInfo map[string]interface{}) (*events.PutRuleOutput, error) {
+	state := events.RuleStateEnabled
+	if !triggerInfo["enabled"].(bool) {
+		state = events.RuleStateDisabled
+	}
+	return cwconn.PutRule(&events.PutRuleInput{
+		Name:               aws.String(ruleName),
+		ScheduleExpression: aws.String(triggerInfo["cron"].(string)),
+		State:              aws.String(state),
+	})
+}
+
+func putScheduleTarget(cwconn *events.CloudWatchEvents, ruleName string, functionArn string, triggerInfo map[string]interface{}) error {
+	target := &events.Target{
+		Id:  aws.String(scheduleTargetId),
+		Arn: aws.String(functionArn),
+	}
+	if v, ok := triggerInfo["input"]; ok && v.(string) != "" {
+		target.Input = aws.String(v.(string))
+	}
+	if v, ok := triggerInfo["retry_policy"]; ok && len(v.([]interface{})) > 0 {
+		policy := v.([]interface{})[0].(map[string]interface{})
+		target.RetryPolicy = &events.RetryPolicy{
+			MaximumEventAgeInSeconds: aws.Int64(int64(policy["maximum_event_age"].(int))),
+			MaximumRetryAttempts:     aws.Int64(int64(policy["maximum_retry_attempts"].(int))),
+		}
+	}
+	_, err := cwconn.PutTargets(&events.PutTargetsInput{
+		Rule:    aws.String(ruleName),
+		Targets: []*events.Target{target},
+	})
+	return err
+}
+
+// expandScheduleInput builds the EventBridge Scheduler request for a trigger.
+// Scheduler invokes the function by assuming PlausibleSchedulerRole, which
+// lives in the same account as the function.
+func expandScheduleInput(functionArn string, scheduleName string, triggerInfo map[string]interface{}) (*scheduler.CreateScheduleInput, error) {
+	parsedArn, err := arn.Parse(functionArn)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing function ARN %q: %s", functionArn, err)
+	}
+	roleArn := arn.ARN{
+		Partition: parsedArn.Partition,
+		Service:   "iam",
+		AccountID: parsedArn.AccountID,
+		Resource:  "role/PlausibleSchedulerRole",
+	}.String()
+
+	state := scheduler.ScheduleStateEnabled
+	if !triggerInfo["enabled"].(bool) {
+		state = scheduler.ScheduleStateDisabled
+	}
+
+	input := &scheduler.CreateScheduleInput{
+		Name:               aws.String(scheduleName),
+		ScheduleExpression: aws.String(triggerInfo["cron"].(string)),
+		State:              aws.String(state),
+		FlexibleTimeWindow: &scheduler.FlexibleTimeWindow{
+			Mode: aws.String(scheduler.FlexibleTimeWindowModeOff),
+		},
+		Target: &scheduler.Target{
+			Arn:     aws.String(functionArn),
+			RoleArn: aws.String(roleArn),
+		},
+	}
+
+	if v, ok := triggerInfo["timezone"]; ok && v.(string) != "" {
+		input.ScheduleExpressionTimezone = aws.String(v.(string))
+	}
+	if v, ok := triggerInfo["start_date"]; ok && v.(string) != "" {
+		t, err := time.Parse(time.RFC3339, v.(string))
+		if err != nil {
+			return nil, fmt.Errorf("schedule_trigger: invalid start_date: %s", err)
+		}
+		input.StartDate = aws.Time(t)
+	}
+	if v, ok := triggerInfo["end_date"]; ok && v.(string) != "" {
+		t, err := time.Parse(time.RFC3339, v.(string))
+		if err != nil {
+			return nil, fmt.Errorf("schedule_trigger: invalid end_date: %s", err)
+		}
+		input.EndDate = aws.Time(t)
+	}
+	if v, ok := triggerInfo["flexible_time_window"]; ok && v.(int) > 0 {
+		input.FlexibleTimeWindow = &scheduler.FlexibleTimeWindow{
+			Mode:                   aws.String(scheduler.FlexibleTimeWindowModeFlexible),
+			MaximumWindowInMinutes: aws.Int64(int64(v.(int))),
+		}
+	}
+	if v, ok := triggerInfo["input"]; ok && v.(string) != "" {
+		input.Target.Input = aws.String(v.(string))
+	}
+	if v, ok := triggerInfo["retry_policy"]; ok && len(v.([]interface{})) > 0 {
+		policy := v.([]interface{})[0].(map[string]interface{})
+		input.Target.RetryPolicy = &scheduler.RetryPolicy{
+			MaximumEventAgeInSeconds: aws.Int64(int64(policy["maximum_event_age"].(int))),
+			MaximumRetryAttempts:     aws.Int64(int64(policy["maximum_retry_attempts"].(int))),
+		}
+	}
+
+	return input, nil
+}
+
+// scheduleTriggerInvocations previews the trigger's upcoming invocations in
+// its time zone, limited to the start and end dates when they are set
+func scheduleTriggerInvocations(triggerInfo map[string]interface{}, from time.Time) ([]time.Time, error) {
+	loc := time.UTC
+	if v, ok := triggerInfo["timezone"]; ok && v.(string) != "" {
+		var err error
+		if loc, err = time.LoadLocation(v.(string)); err != nil {
+			return nil, err
+		}
+	}
+
+	var end time.Time
+	if v, ok := triggerInfo["start_date"]; ok && v.(string) != "" {
+		start, err := time.Parse(time.RFC3339, v.(string))
+		if err != nil {
+			return nil, fmt.Errorf("invalid start_date: %s", err)
+		}
+		if start.After(from) {
+			from = start.Add(-time.Minute)
+		}
+	}
+	if v, ok := triggerInfo["end_date"]; ok && v.(string) != "" {
+		var err error
+		if end, err = time.Parse(time.RFC3339, v.(string)); err != nil {
+			return nil, fmt.Errorf("invalid end_date: %s", err)
+		}
+	}
+
+	times, err := nextScheduleInvocations(triggerInfo["cron"].(string), from, triggerInfo["invocations_after_apply_count"].(int), loc)
+	if err != nil {
+		return nil, err
+	}
+	if !end.IsZero() {
+		for i, t := range times {
+			if t.After(end) {
+				return times[:i], nil
+			}
+		}
+	}
+	return times, nil
+}
+
+// validateScheduleTrigger rejects settings that only EventBridge Scheduler
+// supports when the trigger is backed by a CloudWatch rule
+func validateScheduleTrigger(diff *schema.ResourceDiff) error {
+	v, ok := diff.GetOk("schedule_trigger")
+	if !ok {
+		return nil
+	}
+	triggerInfo := v.([]interface{})[0].(map[string]interface{})
+	if triggerInfo["backend"].(string) == scheduleBackendScheduler {
+		return nil
+	}
+
+	if strings.HasPrefix(triggerInfo["cron"].(string), "at(") {
+		return fmt.Errorf("schedule_trigger: one-time at() expressions require backend = %q", scheduleBackendScheduler)
+	}
+	for _, key := range []string{"timezone", "start_date", "end_date"} {
+		if triggerInfo[key].(string) != "" {
+			return fmt.Errorf("schedule_trigger: %s requires backend = %q", key, scheduleBackendScheduler)
+		}
+	}
+	if triggerInfo["flexible_time_window"].(int) > 0 {
+		return fmt.Errorf("schedule_trigger: flexible_time_window requires backend = %q", scheduleBackendScheduler)
+	}
+	return nil
+}
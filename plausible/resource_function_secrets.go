@@ -0,0 +1,170 @@
+package plausible
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A secret variable names an SSM parameter or a Secrets Manager secret
+// instead of giving a value. By default the variable holds the reference, and
+// the function is granted read access to look the value up at runtime. With
+// resolve set, the provider looks the value up whenever it updates the
+// function's configuration, and Lambda keeps it encrypted at rest with
+// kms_key_arn.
+
+const (
+	secretSourceParameter = "ssm"
+	secretSourceSecret    = "secretsmanager"
+)
+
+func secretsPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-secrets", functionName)
+}
+
+// secretReferenceArn returns the ARN of a secret variable's reference, which
+// is either an ARN or the name of a parameter in the function's own account
+// and region
+func secretReferenceArn(d *schema.ResourceData, reference string) (string, error) {
+	if strings.HasPrefix(reference, "arn:") {
+		parsed, err := arn.Parse(reference)
+		if err != nil {
+			return "", fmt.Errorf("Error parsing secret reference %q: %s", reference, err)
+		}
+		if parsed.Service != secretSourceParameter && parsed.Service != secretSourceSecret {
+			return "", fmt.Errorf("secret reference %q is neither an SSM parameter nor a Secrets Manager secret", reference)
+		}
+		return reference, nil
+	}
+
+	functionArn, err := arn.Parse(d.Id())
+	if err != nil {
+		return "", fmt.Errorf("Error parsing function ARN %q: %s", d.Id(), err)
+	}
+	return arn.ARN{
+		Partition: functionArn.Partition,
+		Service:   secretSourceParameter,
+		Region:    functionArn.Region,
+		AccountID: functionArn.AccountID,
+		Resource:  "parameter/" + strings.TrimPrefix(reference, "/"),
+	}.String(), nil
+}
+
+// resolveSecret looks up the current value of a secret variable
+func resolveSecret(m interface{}, reference string) (string, error) {
+	if strings.HasPrefix(reference, "arn:") {
+		if parsed, _ := arn.Parse(reference); parsed.Service == secretSourceSecret {
+			out, err := m.(*AWSClient).secretsmanagerconn.GetSecretValue(&secretsmanager.GetSecretValueInput{
+				SecretId: aws.String(reference),
+			})
+			if err != nil {
+				return "", fmt.Errorf("Error resolving secret %s: %s", reference, err)
+			}
+			return aws.StringValue(out.SecretString), nil
+		}
+	}
+
+	out, err := m.(*AWSClient).ssmconn.GetParameter(&ssm.GetParameterInput{
+		Name:           aws.String(reference),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error resolving parameter %s: %s", reference, err)
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// functionSecretVariables returns the environment variables for the secret
+// variables, resolving those that ask for it
+func functionSecretVariables(d *schema.ResourceData, m interface{}) (map[string]string, error) {
+	variables := map[string]string{}
+	for _, s := range d.Get("secret_variables").([]interface{}) {
+		secret := s.(map[string]interface{})
+		reference := secret["reference"].(string)
+		if !secret["resolve"].(bool) {
+			variables[secret["name"].(string)] = reference
+			continue
+		}
+		value, err := resolveSecret(m, reference)
+		if err != nil {
+			return nil, err
+		}
+		variables[secret["name"].(string)] = value
+	}
+	return variables, nil
+}
+
+// putFunctionSecrets grants the function read access to the secrets it looks
+// up at runtime, and decrypt access to the key its environment is encrypted
+// with
+func putFunctionSecrets(d *schema.ResourceData, m interface{}) error {
+	parameters, secrets := []string{}, []string{}
+	for _, s := range d.Get("secret_variables").([]interface{}) {
+		secret := s.(map[string]interface{})
+		if secret["resolve"].(bool) {
+			continue
+		}
+		referenceArn, err := secretReferenceArn(d, secret["reference"].(string))
+		if err != nil {
+			return err
+		}
+		parsed, _ := arn.Parse(referenceArn)
+		if parsed.Service == secretSourceSecret {
+			secrets = append(secrets, referenceArn)
+		} else {
+			parameters = append(parameters, referenceArn)
+		}
+	}
+
+	statements := []*iamPolicyStatement{}
+	if len(parameters) > 0 {
+		statements = append(statements, &iamPolicyStatement{
+			Effect:   "Allow",
+			Action:   []string{"ssm:GetParameter", "ssm:GetParameters"},
+			Resource: parameters,
+		})
+	}
+	if len(secrets) > 0 {
+		statements = append(statements, &iamPolicyStatement{
+			Effect:   "Allow",
+			Action:   []string{"secretsmanager:GetSecretValue"},
+			Resource: secrets,
+		})
+	}
+	if kmsKeyArn := d.Get("kms_key_arn").(string); kmsKeyArn != "" {
+		statements = append(statements, &iamPolicyStatement{
+			Effect:   "Allow",
+			Action:   []string{"kms:Decrypt"},
+			Resource: []string{kmsKeyArn},
+		})
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), secretsPolicyName(d.Get("function_name").(string)), statements)
+}
+
+// validateFunctionSecrets checks that secret variable names are unique and
+// that references are ARNs of the right services or parameter names
+func validateFunctionSecrets(diff *schema.ResourceDiff) error {
+	names := map[string]bool{}
+	for _, s := range diff.Get("secret_variables").([]interface{}) {
+		secret := s.(map[string]interface{})
+		name := secret["name"].(string)
+		if names[name] {
+			return fmt.Errorf("secret_variables: more than one secret variable is named %q", name)
+		}
+		names[name] = true
+
+		reference := secret["reference"].(string)
+		if strings.HasPrefix(reference, "arn:") {
+			parsed, err := arn.Parse(reference)
+			if err != nil || (parsed.Service != secretSourceParameter && parsed.Service != secretSourceSecret) {
+				return fmt.Errorf("secret_variables: %q is neither an SSM parameter nor a Secrets Manager secret", reference)
+			}
+		}
+	}
+	return nil
+}
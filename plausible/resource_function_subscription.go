@@ -0,0 +1,323 @@
+package plausible
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const (
+	// SQS visibility timeouts cannot exceed 12 hours
+	sqsMaxVisibilityTimeout = 43200
+
+	// AWS recommends a queue visibility timeout of at least six times the
+	// timeout of the function that consumes it
+	sqsVisibilityTimeoutFactor = 6
+)
+
+// To trigger a Lambda from a subscription, we create an SQS queue to subscribe to the
+// existing SNS topic, and trigger the Lambda from that queue. This provides shock
+// absorption and prevents message loss in the case of throughput that exceeds
+// concurrency limits. Messages that repeatedly fail are moved to a dead-letter
+// queue after max_receive_count receives.
+func createSubscriptionTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	sqsconn := m.(*AWSClient).sqsconn
+	snsconn := m.(*AWSClient).snsconn
+	topicArn := triggerInfo["publisher_id"].(string)
+	queueName := resource.UniqueId()
+
+	// Create the dead-letter queue first, so that the main queue can redrive to it
+	dlqUrl, dlqArn, err := createQueue(sqsconn, queueName+"-dlq", map[string]*string{})
+	if err != nil {
+		return fmt.Errorf("Creating SQS dead-letter queue failed: %s", err)
+	}
+	triggerInfo["dead_letter_queue_id"] = dlqArn
+	triggerInfo["dead_letter_queue_url"] = dlqUrl
+
+	visibilityTimeout := subscriptionVisibilityTimeout(d.Get("timeout").(int), triggerInfo)
+	redrivePolicy, err := subscriptionRedrivePolicy(dlqArn, triggerInfo)
+	if err != nil {
+		return err
+	}
+	queueUrl, queueArn, err := createQueue(sqsconn, queueName, map[string]*string{
+		sqs.QueueAttributeNameVisibilityTimeout: aws.String(strconv.Itoa(visibilityTimeout)),
+		sqs.QueueAttributeNameRedrivePolicy:     aws.String(redrivePolicy),
+	})
+	if err != nil {
+		return fmt.Errorf("Creating SQS queue failed: %s", err)
+	}
+	triggerInfo["queue_id"] = queueArn
+	triggerInfo["queue_url"] = queueUrl
+	triggerInfo["visibility_timeout"] = visibilityTimeout
+
+	// Allow the topic to deliver to the queue
+	queuePolicy, err := subscriptionQueuePolicy(queueArn, topicArn)
+	if err != nil {
+		return err
+	}
+	_, err = sqsconn.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: aws.String(queueUrl),
+		Attributes: map[string]*string{
+			sqs.QueueAttributeNamePolicy: aws.String(queuePolicy),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Setting SQS queue policy failed: %s", err)
+	}
+
+	// Create topic subscription for SQS queue
+	output, err := snsconn.Subscribe(&sns.SubscribeInput{
+		Protocol:              aws.String("sqs"),
+		Endpoint:              aws.String(queueArn),
+		TopicArn:              aws.String(topicArn),
+		Attributes:            expandSubscriptionAttributes(triggerInfo),
+		ReturnSubscriptionArn: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("Creating SNS subscription failed: %s", err)
+	}
+	triggerInfo["subscription_id"] = aws.StringValue(output.SubscriptionArn)
+
+	// Create lambda event source mapping
+	params := &lambda.CreateEventSourceMappingInput{
+		EventSourceArn:                 aws.String(queueArn),
+		FunctionName:                   aws.String(functionTargetArn(d)),
+		Enabled:                        aws.Bool(true),
+		BatchSize:                      aws.Int64(int64(triggerInfo["batch_size"].(int))),
+		MaximumBatchingWindowInSeconds: aws.Int64(int64(triggerInfo["maximum_batching_window"].(int))),
+		FunctionResponseTypes:          expandSubscriptionResponseTypes(triggerInfo),
+	}
+	if v := triggerInfo["max_concurrency"].(int); v > 0 {
+		params.ScalingConfig = &lambda.ScalingConfig{
+			MaximumConcurrency: aws.Int64(int64(v)),
+		}
+	}
+	mapping, err := conn.CreateEventSourceMapping(params)
+	if err != nil {
+		return fmt.Errorf("Creating Lambda event source mapping: %s", err)
+	}
+	triggerInfo["event_source_mapping_id"] = aws.StringValue(mapping.UUID)
+
+	return nil
+}
+
+// updateSubscriptionTrigger applies delivery and batching changes in place.
+// Subscribing to a different publisher replaces the trigger.
+func updateSubscriptionTrigger(d *schema.ResourceData, m interface{}, oldInfo, triggerInfo map[string]interface{}) error {
+	if oldInfo["publisher_id"].(string) != triggerInfo["publisher_id"].(string) {
+		if err := deleteSubscriptionTrigger(d, m, oldInfo); err != nil {
+			return err
+		}
+		return createSubscriptionTrigger(d, m, triggerInfo)
+	}
+
+	for _, key := range []string{
+		"queue_id", "queue_url", "dead_letter_queue_id", "dead_letter_queue_url",
+		"subscription_id", "event_source_mapping_id",
+	} {
+		triggerInfo[key] = oldInfo[key]
+	}
+
+	conn := m.(*AWSClient).lambdaconn
+	sqsconn := m.(*AWSClient).sqsconn
+	snsconn := m.(*AWSClient).snsconn
+
+	// The SNS API sets one subscription attribute per call
+	attributes := expandSubscriptionAttributes(triggerInfo)
+	oldAttributes := expandSubscriptionAttributes(oldInfo)
+	for _, name := range []string{"FilterPolicy", "FilterPolicyScope", "RawMessageDelivery"} {
+		if aws.StringValue(attributes[name]) == aws.StringValue(oldAttributes[name]) {
+			continue
+		}
+		value := attributes[name]
+		if value == nil && name == "FilterPolicy" {
+			// An empty filter policy removes filtering altogether
+			value = aws.String("")
+		} else if value == nil {
+			continue
+		}
+		_, err := snsconn.SetSubscriptionAttributes(&sns.SetSubscriptionAttributesInput{
+			SubscriptionArn: aws.String(triggerInfo["subscription_id"].(string)),
+			AttributeName:   aws.String(name),
+			AttributeValue:  value,
+		})
+		if err != nil {
+			return fmt.Errorf("Updating SNS subscription attribute %s failed: %s", name, err)
+		}
+	}
+
+	visibilityTimeout := subscriptionVisibilityTimeout(d.Get("timeout").(int), triggerInfo)
+	redrivePolicy, err := subscriptionRedrivePolicy(triggerInfo["dead_letter_queue_id"].(string), triggerInfo)
+	if err != nil {
+		return err
+	}
+	_, err = sqsconn.SetQueueAttributes(&sqs.SetQueueAttributesInput{
+		QueueUrl: aws.String(triggerInfo["queue_url"].(string)),
+		Attributes: map[string]*string{
+			sqs.QueueAttributeNameVisibilityTimeout: aws.String(strconv.Itoa(visibilityTimeout)),
+			sqs.QueueAttributeNameRedrivePolicy:     aws.String(redrivePolicy),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("Updating SQS queue attributes failed: %s", err)
+	}
+	triggerInfo["visibility_timeout"] = visibilityTimeout
+
+	// An empty scaling config removes the concurrency limit
+	scalingConfig := &lambda.ScalingConfig{}
+	if v := triggerInfo["max_concurrency"].(int); v > 0 {
+		scalingConfig.MaximumConcurrency = aws.Int64(int64(v))
+	}
+	_, err = conn.UpdateEventSourceMapping(&lambda.UpdateEventSourceMappingInput{
+		UUID:                           aws.String(triggerInfo["event_source_mapping_id"].(string)),
+		BatchSize:                      aws.Int64(int64(triggerInfo["batch_size"].(int))),
+		MaximumBatchingWindowInSeconds: aws.Int64(int64(triggerInfo["maximum_batching_window"].(int))),
+		FunctionResponseTypes:          expandSubscriptionResponseTypes(triggerInfo),
+		ScalingConfig:                  scalingConfig,
+	})
+	if err != nil {
+		return fmt.Errorf("Updating Lambda event source mapping failed: %s", err)
+	}
+
+	return nil
+}
+
+func deleteSubscriptionTrigger(d *schema.ResourceData, m interface{}, triggerInfo map[string]interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	sqsconn := m.(*AWSClient).sqsconn
+	snsconn := m.(*AWSClient).snsconn
+
+	// Delete topic subscription
+	_, err := snsconn.Unsubscribe(&sns.UnsubscribeInput{
+		SubscriptionArn: aws.String(triggerInfo["subscription_id"].(string)),
+	})
+	if err != nil && !isAWSErr(err, sns.ErrCodeNotFoundException, "") {
+		return fmt.Errorf("Error removing SNS subscription: %s", err)
+	}
+
+	// Delete Lambda event source mapping
+	_, err = conn.DeleteEventSourceMapping(&lambda.DeleteEventSourceMappingInput{
+		UUID: aws.String(triggerInfo["event_source_mapping_id"].(string)),
+	})
+	if err != nil && !isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		return fmt.Errorf("Error removing Lambda event source mapping: %s", err)
+	}
+
+	// Delete the queue and its dead-letter queue
+	for _, key := range []string{"queue_url", "dead_letter_queue_url"} {
+		_, err = sqsconn.DeleteQueue(&sqs.DeleteQueueInput{
+			QueueUrl: aws.String(triggerInfo[key].(string)),
+		})
+		if err != nil && !isAWSErr(err, sqs.ErrCodeQueueDoesNotExist, "") {
+			return fmt.Errorf("Error removing SQS queue: %s", err)
+		}
+	}
+
+	return nil
+}
+
+func createQueue(sqsconn *sqs.SQS, name string, attributes map[string]*string) (string, string, error) {
+	queueOutput, err := sqsconn.CreateQueue(&sqs.CreateQueueInput{
+		QueueName:  aws.String(name),
+		Attributes: attributes,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	queueAttributes, err := sqsconn.GetQueueAttributes(&sqs.GetQueueAttributesInput{
+		QueueUrl:       queueOutput.QueueUrl,
+		AttributeNames: []*string{aws.String(sqs.QueueAttributeNameQueueArn)},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("Getting queue attributes failed: %s", err)
+	}
+
+	return aws.StringValue(queueOutput.QueueUrl), aws.StringValue(queueAttributes.Attributes[sqs.QueueAttributeNameQueueArn]), nil
+}
+
+func expandSubscriptionAttributes(triggerInfo map[string]interface{}) map[string]*string {
+	attributes := map[string]*string{
+		"RawMessageDelivery": aws.String(strconv.FormatBool(triggerInfo["raw_message_delivery"].(bool))),
+	}
+	if v, ok := triggerInfo["filter_policy"]; ok && v.(string) != "" {
+		attributes["FilterPolicy"] = aws.String(v.(string))
+		attributes["FilterPolicyScope"] = aws.String(triggerInfo["filter_policy_scope"].(string))
+	}
+	return attributes
+}
+
+// expandSubscriptionResponseTypes lets the function report the individual
+// records of a batch that failed, so that only those are retried
+func expandSubscriptionResponseTypes(triggerInfo map[string]interface{}) []*string {
+	responseTypes := []*string{}
+	if triggerInfo["report_batch_item_failures"].(bool) {
+		responseTypes = append(responseTypes, aws.String(lambda.FunctionResponseTypeReportBatchItemFailures))
+	}
+	return responseTypes
+}
+
+// subscriptionVisibilityTimeout derives the queue visibility timeout from the
+// function timeout, so that messages are not redelivered while a batch is
+// still being processed
+func subscriptionVisibilityTimeout(functionTimeout int, triggerInfo map[string]interface{}) int {
+	timeout := sqsVisibilityTimeoutFactor*functionTimeout + triggerInfo["maximum_batching_window"].(int)
+	if timeout > sqsMaxVisibilityTimeout {
+		return sqsMaxVisibilityTimeout
+	}
+	return timeout
+}
+
+func subscriptionRedrivePolicy(dlqArn string, triggerInfo map[string]interface{}) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"deadLetterTargetArn": dlqArn,
+		"maxReceiveCount":     triggerInfo["max_receive_count"].(int),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error building SQS redrive policy: %s", err)
+	}
+	return string(policy), nil
+}
+
+func subscriptionQueuePolicy(queueArn string, topicArn string) (string, error) {
+	policy, err := json.Marshal(map[string]interface{}{
+		"Version": "2012-10-17",
+		"Statement": []map[string]interface{}{
+			{
+				"Effect":    "Allow",
+				"Principal": map[string]string{"Service": "sns.amazonaws.com"},
+				"Action":    "sqs:SendMessage",
+				"Resource":  queueArn,
+				"Condition": map[string]interface{}{
+					"ArnEquals": map[string]string{"aws:SourceArn": topicArn},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error building SQS queue policy: %s", err)
+	}
+	return string(policy), nil
+}
+
+// validateSubscriptionTrigger checks batching settings that the Lambda API
+// would otherwise only reject at apply time
+func validateSubscriptionTrigger(diff *schema.ResourceDiff) error {
+	v, ok := diff.GetOk("subscription_trigger")
+	if !ok {
+		return nil
+	}
+	triggerInfo := v.([]interface{})[0].(map[string]interface{})
+	if triggerInfo["batch_size"].(int) > 10 && triggerInfo["maximum_batching_window"].(int) == 0 {
+		return fmt.Errorf("subscription_trigger: a batch_size greater than 10 requires a maximum_batching_window of at least 1 second")
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package plausible
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A vpc block places the function in the given subnets, where Lambda reaches
+// them through network interfaces it manages. The function's role gets an
+// inline policy to manage those interfaces. Lambda keeps the interfaces for a
+// while after the function is deleted, which would stop the subnets and
+// security groups from being destroyed with it, so deleting a function waits
+// for them and removes those that have been released.
+
+// functionEniTimeout is how long deleting a function waits for its network
+// interfaces to go
+const functionEniTimeout = 40 * time.Minute
+
+// enisInUseError is the error while function network interfaces are in use
+type enisInUseError struct {
+	functionName string
+	count        int
+}
+
+func (e *enisInUseError) Error() string {
+	return fmt.Sprintf("%d network interfaces of function %s are still in use", e.count, e.functionName)
+}
+
+func vpcPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-vpc", functionName)
+}
+
+func expandVpcConfig(d *schema.ResourceData) *lambda.VpcConfig {
+	v, ok := d.GetOk("vpc")
+	if !ok {
+		// An empty config takes the function out of its VPC
+		return &lambda.VpcConfig{
+			SubnetIds:        []*string{},
+			SecurityGroupIds: []*string{},
+		}
+	}
+	vpc := v.([]interface{})[0].(map[string]interface{})
+	return &lambda.VpcConfig{
+		SubnetIds:               aws.StringSlice(expandStringSet(vpc["subnet_ids"].(*schema.Set))),
+		SecurityGroupIds:        aws.StringSlice(expandStringSet(vpc["security_group_ids"].(*schema.Set))),
+		Ipv6AllowedForDualStack: aws.Bool(vpc["ipv6_allowed_for_dual_stack"].(bool)),
+	}
+}
+
+func flattenVpcConfig(config *lambda.VpcConfigResponse) []interface{} {
+	if config == nil || len(config.SubnetIds) == 0 {
+		return []interface{}{}
+	}
+	return []interface{}{map[string]interface{}{
+		"subnet_ids":                  aws.StringValueSlice(config.SubnetIds),
+		"security_group_ids":          aws.StringValueSlice(config.SecurityGroupIds),
+		"ipv6_allowed_for_dual_stack": aws.BoolValue(config.Ipv6AllowedForDualStack),
+		"vpc_id":                      aws.StringValue(config.VpcId),
+	}}
+}
+
+// putFunctionVpcPolicy lets a function in a VPC manage its network
+// interfaces, and removes that grant otherwise
+func putFunctionVpcPolicy(d *schema.ResourceData, m interface{}) error {
+	statements := []*iamPolicyStatement{}
+	if _, ok := d.GetOk("vpc"); ok {
+		statements = append(statements, &iamPolicyStatement{
+			Effect: "Allow",
+			Action: []string{
+				"ec2:CreateNetworkInterface",
+				"ec2:DescribeNetworkInterfaces",
+				"ec2:DescribeSubnets",
+				"ec2:DeleteNetworkInterface",
+				"ec2:AssignPrivateIpAddresses",
+				"ec2:UnassignPrivateIpAddresses",
+			},
+			Resource: []string{"*"},
+		})
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), vpcPolicyName(d.Get("function_name").(string)), statements)
+}
+
+// waitForFunctionActive waits for Lambda to finish creating the function,
+// which includes its network interfaces
+func waitForFunctionActive(m interface{}, functionName string) error {
+	err := m.(*AWSClient).lambdaconn.WaitUntilFunctionActiveV2(&lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for function %s to become active: %s", functionName, err)
+	}
+	return nil
+}
+
+// waitForFunctionUpdated waits for Lambda to finish applying a configuration
+// change, which may move the function's network interfaces
+func waitForFunctionUpdated(m interface{}, functionName string) error {
+	err := m.(*AWSClient).lambdaconn.WaitUntilFunctionUpdatedV2(&lambda.GetFunctionInput{
+		FunctionName: aws.String(functionName),
+	})
+	if err != nil {
+		return fmt.Errorf("Error waiting for function %s to update: %s", functionName, err)
+	}
+	return nil
+}
+
+// deleteFunctionEnis waits for Lambda to release the network interfaces it
+// created for the function and deletes them. Interfaces that other functions
+// still share are left once the wait is over, without failing the delete.
+func deleteFunctionEnis(d *schema.ResourceData, m interface{}) error {
+	v, ok := d.GetOk("vpc")
+	if !ok {
+		return nil
+	}
+	conn := m.(*AWSClient).ec2conn
+	functionName := d.Get("function_name").(string)
+	vpc := v.([]interface{})[0].(map[string]interface{})
+
+	input := &ec2.DescribeNetworkInterfacesInput{
+		Filters: []*ec2.Filter{
+			{
+				Name:   aws.String("description"),
+				Values: []*string{aws.String(fmt.Sprintf("AWS Lambda VPC ENI-%s*", functionName))},
+			},
+			{
+				Name:   aws.String("subnet-id"),
+				Values: aws.StringSlice(expandStringSet(vpc["subnet_ids"].(*schema.Set))),
+			},
+		},
+	}
+	err := resource.Retry(functionEniTimeout, func() *resource.RetryError {
+		out, err := conn.DescribeNetworkInterfaces(input)
+		if err != nil {
+			return resource.NonRetryableError(err)
+		}
+		remaining := 0
+		for _, eni := range out.NetworkInterfaces {
+			if aws.StringValue(eni.Status) != ec2.NetworkInterfaceStatusAvailable {
+				remaining++
+				continue
+			}
+			_, err := conn.DeleteNetworkInterface(&ec2.DeleteNetworkInterfaceInput{
+				NetworkInterfaceId: eni.NetworkInterfaceId,
+			})
+			if err != nil && !isAWSErr(err, "InvalidNetworkInterfaceID.NotFound", "") {
+				return resource.NonRetryableError(err)
+			}
+		}
+		if remaining > 0 {
+			return resource.RetryableError(&enisInUseError{functionName: functionName, count: remaining})
+		}
+		return nil
+	})
+	// Retry gives back the last error when it times out
+	if _, ok := err.(*enisInUseError); ok {
+		log.Printf("[WARN] Leaving network interfaces of function %s: %s", functionName, err)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error deleting network interfaces of function %s: %s", functionName, err)
+	}
+	return nil
+}
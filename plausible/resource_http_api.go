@@ -5,14 +5,19 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/arn"
 	"github.com/aws/aws-sdk-go/service/apigateway"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
+// httpApiStageName is the stage every API is deployed to
+const httpApiStageName = "default"
+
 func resourceHttpApi() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourceHttpApiCreate,
@@ -40,10 +45,50 @@ func resourceHttpApi() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"tracing": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateTracingMode,
+			},
 		},
 	}
 }
 
+// putHttpApiStageTracing turns X-Ray tracing on or off on the API's stage.
+// API Gateway passes on the trace context of incoming requests either way.
+// The stage only exists once the API has been deployed with its integrations,
+// so an API that has not been deployed yet gets a warning that active tracing
+// is still to be applied. Read reports the stage's tracing once it exists,
+// which plans the change then.
+func putHttpApiStageTracing(d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	tracingEnabled := tracingMode(d, m) == lambda.TracingModeActive
+	_, err := m.(*AWSClient).apigatewayconn.UpdateStage(&apigateway.UpdateStageInput{
+		RestApiId: aws.String(d.Id()),
+		StageName: aws.String(httpApiStageName),
+		PatchOperations: []*apigateway.PatchOperation{
+			{
+				Op:    aws.String(apigateway.OpReplace),
+				Path:  aws.String("/tracingEnabled"),
+				Value: aws.String(strconv.FormatBool(tracingEnabled)),
+			},
+		},
+	})
+	if isAWSErr(err, apigateway.ErrCodeNotFoundException, "") {
+		if !tracingEnabled {
+			return nil
+		}
+		return diag.Diagnostics{{
+			Severity: diag.Warning,
+			Summary:  fmt.Sprintf("API Gateway %s is not traced yet", d.Id()),
+			Detail:   fmt.Sprintf("The API has no %s stage until it is deployed. Active tracing is applied on the first apply after that.", httpApiStageName),
+		}}
+	}
+	if err != nil {
+		return diag.Errorf("Error updating tracing of API Gateway stage: %s", err)
+	}
+	return nil
+}
+
 func resourceHttpApiCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	// var diags diag.Diagnostics
 
@@ -80,6 +125,11 @@ func resourceHttpApiCreate(ctx context.Context, d *schema.ResourceData, m interf
 	}.String()
 	d.Set("uri", rest_api_arn)
 
+	diags := putHttpApiStageTracing(d, m)
+	if diags.HasError() {
+		return diags
+	}
+
 	// rscs, err := conn.GetResources(&apigateway.GetResourcesInput{
 	// 	RestApiId: gateway.Id,
 	// })
@@ -92,29 +142,7 @@ func resourceHttpApiCreate(ctx context.Context, d *schema.ResourceData, m interf
 	// }
 	// d.Set("resources", rm)
 
-	// // Deployment
-	// deploymentInput := apigateway.CreateDeploymentInput{
-	// 	RestApiId: gateway.Id,
-	// 	StageName: aws.String("default"),
-	// }
-	// _, err = conn.CreateDeployment(&deploymentInput)
-	// if err != nil {
-	// 	return diag.Errorf("Error creating API Gateway Deployment: %s", err)
-	// }
-
-	// // Stage
-	// stageInput := apigateway.CreateStageInput{
-	// 	RestApiId:    gateway.Id,
-	// 	StageName:    aws.String("default"),
-	// 	DeploymentId: aws.String(d.Get("deployment_id").(string)),
-	// }
-
-	// _, err = conn.CreateStage(&stageInput)
-	// if err != nil {
-	// 	return diag.Errorf("Error creating API Gateway Stage: %s", err)
-	// }
-
-	return resourceHttpApiRead(ctx, d, m)
+	return append(diags, resourceHttpApiRead(ctx, d, m)...)
 }
 
 func resourceHttpApiRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
@@ -144,6 +172,26 @@ func resourceHttpApiRead(ctx context.Context, d *schema.ResourceData, m interfac
 	}
 	d.Set("resources", rm)
 
+	// Tracing is only read back when the stage differs from what is wanted,
+	// so that APIs using the provider's default do not show a change
+	stage, err := conn.GetStage(&apigateway.GetStageInput{
+		RestApiId: aws.String(d.Id()),
+		StageName: aws.String(httpApiStageName),
+	})
+	switch {
+	case isAWSErr(err, apigateway.ErrCodeNotFoundException, ""):
+	case err != nil:
+		return diag.Errorf("Error reading API Gateway stage: %s", err)
+	default:
+		mode := lambda.TracingModePassThrough
+		if aws.BoolValue(stage.TracingEnabled) {
+			mode = lambda.TracingModeActive
+		}
+		if mode != tracingMode(d, m) {
+			d.Set("tracing", mode)
+		}
+	}
+
 	return diags
 }
 
@@ -151,6 +199,13 @@ func resourceHttpApiUpdate(ctx context.Context, d *schema.ResourceData, m interf
 	var diags diag.Diagnostics
 	conn := m.(*AWSClient).apigatewayconn
 
+	if d.HasChange("tracing") {
+		diags = putHttpApiStageTracing(d, m)
+		if diags.HasError() {
+			return diags
+		}
+	}
+
 	if d.HasChange("spec_body") {
 		if body, ok := d.GetOk("body"); ok {
 			log.Printf("[DEBUG] Updating API Gateway from OpenAPI spec: %s", d.Id())
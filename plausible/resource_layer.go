@@ -0,0 +1,234 @@
+package plausible
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// A layer packages a directory of code shared between functions, which Lambda
+// extracts to /opt. Each change to the content, runtimes or architectures
+// publishes a new version, and functions pick it up through their layers.
+// Earlier versions are kept until the layer is deleted, so that functions
+// still using them keep working until they are updated. Versions are
+// published inline, which limits a packaged layer to 50 MB.
+
+func resourceLayer() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceLayerCreate,
+		ReadContext:   resourceLayerRead,
+		UpdateContext: resourceLayerUpdate,
+		DeleteContext: resourceLayerDelete,
+		CustomizeDiff: resourceLayerCustomizeDiff,
+		Schema: map[string]*schema.Schema{
+			"source": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ForceNew: true,
+			},
+			"description": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"runtimes": &schema.Schema{
+				Type:     schema.TypeSet,
+				Required: true,
+				MaxItems: 15,
+				Elem: &schema.Schema{
+					Type:             schema.TypeString,
+					ValidateDiagFunc: validateRuntime,
+				},
+			},
+			"architectures": &schema.Schema{
+				Type:     schema.TypeSet,
+				Optional: true,
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateArchitecture,
+				},
+			},
+			"source_code_hash": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"arn": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"layer_arn": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"version": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+// layerPackage zips the layer's source directory, which fails the plan for
+// layers too large to publish
+func layerPackage(source string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	if err := packageDirectory(w, source, map[string]bool{}); err != nil {
+		return nil, fmt.Errorf("Error packaging layer %s: %s", source, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("Error packaging layer %s: %s", source, err)
+	}
+	if buf.Len() > directUploadLimit {
+		return nil, fmt.Errorf("layer %s is %d bytes packaged, more than the %d Lambda accepts", source, buf.Len(), directUploadLimit)
+	}
+	return buf.Bytes(), nil
+}
+
+func publishLayerVersion(d *schema.ResourceData, m interface{}) error {
+	conn := m.(*AWSClient).lambdaconn
+	name := d.Get("name").(string)
+
+	file, err := layerPackage(d.Get("source").(string))
+	if err != nil {
+		return err
+	}
+	input := &lambda.PublishLayerVersionInput{
+		LayerName:          aws.String(name),
+		Content:            &lambda.LayerVersionContentInput{ZipFile: file},
+		CompatibleRuntimes: aws.StringSlice(expandStringSet(d.Get("runtimes").(*schema.Set))),
+	}
+	if v, ok := d.GetOk("description"); ok {
+		input.Description = aws.String(v.(string))
+	}
+	if v, ok := d.GetOk("architectures"); ok {
+		input.CompatibleArchitectures = aws.StringSlice(expandStringSet(v.(*schema.Set)))
+	}
+
+	out, err := conn.PublishLayerVersion(input)
+	if err != nil {
+		return fmt.Errorf("Error publishing layer %s: %s", name, err)
+	}
+	d.SetId(aws.StringValue(out.LayerVersionArn))
+	return nil
+}
+
+func resourceLayerCreate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if _, ok := d.GetOk("name"); !ok {
+		d.Set("name", resource.UniqueId())
+	}
+	if err := publishLayerVersion(d, m); err != nil {
+		return diag.FromErr(err)
+	}
+	return resourceLayerRead(ctx, d, m)
+}
+
+func resourceLayerRead(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	conn := m.(*AWSClient).lambdaconn
+
+	layer, err := conn.GetLayerVersionByArn(&lambda.GetLayerVersionByArnInput{
+		Arn: aws.String(d.Id()),
+	})
+	if isAWSErr(err, lambda.ErrCodeResourceNotFoundException, "") {
+		d.SetId("")
+		return diags
+	}
+	if err != nil {
+		return diag.Errorf("Error reading layer %s: %s", d.Id(), err)
+	}
+	d.Set("arn", layer.LayerVersionArn)
+	d.Set("layer_arn", layer.LayerArn)
+	d.Set("version", layer.Version)
+	d.Set("description", layer.Description)
+	d.Set("runtimes", aws.StringValueSlice(layer.CompatibleRuntimes))
+	d.Set("architectures", aws.StringValueSlice(layer.CompatibleArchitectures))
+	if layer.Content != nil {
+		d.Set("source_code_hash", layer.Content.CodeSha256)
+	}
+	return diags
+}
+
+// resourceLayerUpdate publishes a new version, since published versions
+// cannot change
+func resourceLayerUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	if d.HasChanges("source_code_hash", "description", "runtimes", "architectures") {
+		if err := publishLayerVersion(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
+	return resourceLayerRead(ctx, d, m)
+}
+
+// resourceLayerDelete deletes every version of the layer
+func resourceLayerDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
+	var diags diag.Diagnostics
+	conn := m.(*AWSClient).lambdaconn
+	name := d.Get("name").(string)
+
+	versions := []*int64{}
+	err := conn.ListLayerVersionsPages(&lambda.ListLayerVersionsInput{
+		LayerName: aws.String(name),
+	}, func(page *lambda.ListLayerVersionsOutput, lastPage bool) bool {
+		for _, version := range page.LayerVersions {
+			versions = append(versions, version.Version)
+		}
+		return !lastPage
+	})
+	if err != nil {
+		return diag.Errorf("Error listing versions of layer %s: %s", name, err)
+	}
+
+	for _, version := range versions {
+		_, err := conn.DeleteLayerVersion(&lambda.DeleteLayerVersionInput{
+			LayerName:     aws.String(name),
+			VersionNumber: version,
+		})
+		if err != nil {
+			return diag.Errorf("Error deleting version %d of layer %s: %s", aws.Int64Value(version), name, err)
+		}
+	}
+	return diags
+}
+
+// resourceLayerCustomizeDiff packages the source to find out whether it has
+// changed, which publishes a new version
+func resourceLayerCustomizeDiff(ctx context.Context, diff *schema.ResourceDiff, m interface{}) error {
+	if !diff.NewValueKnown("source") {
+		return diff.SetNewComputed("source_code_hash")
+	}
+	file, err := layerPackage(diff.Get("source").(string))
+	if err != nil {
+		return err
+	}
+	if hash := packageHash(file); hash != diff.Get("source_code_hash").(string) {
+		if err := diff.SetNew("source_code_hash", hash); err != nil {
+			return err
+		}
+	}
+	if diff.Id() == "" {
+		return nil
+	}
+	for _, key := range []string{"source_code_hash", "description", "runtimes", "architectures"} {
+		if diff.HasChange(key) {
+			for _, computed := range []string{"arn", "version"} {
+				if err := diff.SetNewComputed(computed); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -128,12 +128,22 @@ func resourceObjectStoreCreate(ctx context.Context, d *schema.ResourceData, m in
 		return diag.Errorf("Error validating S3 bucket name: %s", err)
 	}
 
+	keyComponents := expandKeyComponents(d.Get("key_component").(*schema.Set))
+	if err := validateKeyComponents(keyComponents); err != nil {
+		return diag.FromErr(err)
+	}
+
 	_, err := conn.CreateBucket(req)
 	if err != nil {
 		return diag.Errorf("Error creating S3 bucket: %s", err)
 	}
 
 	d.SetId(store_name)
+
+	if err := registryPutObjectStore(m.(*AWSClient).AppName, d.Id(), keyComponents); err != nil {
+		return diag.Errorf("Error registering object store: %s", err)
+	}
+
 	return resourceObjectStoreRead(ctx, d, m)
 }
 
@@ -180,6 +190,16 @@ func resourceObjectStoreRead(ctx context.Context, d *schema.ResourceData, m inte
 func resourceObjectStoreUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
 	// conn := m.(*AWSClient).s3conn
 
+	if d.HasChange("key_component") {
+		keyComponents := expandKeyComponents(d.Get("key_component").(*schema.Set))
+		if err := validateKeyComponents(keyComponents); err != nil {
+			return diag.FromErr(err)
+		}
+		if err := registryPutObjectStore(m.(*AWSClient).AppName, d.Id(), keyComponents); err != nil {
+			return diag.Errorf("Error registering object store: %s", err)
+		}
+	}
+
 	return resourceObjectStoreRead(ctx, d, m)
 }
 
@@ -199,9 +219,27 @@ func resourceObjectStoreDelete(ctx context.Context, d *schema.ResourceData, m in
 		return diag.Errorf("error deleting S3 Bucket (%s): %s", d.Id(), err)
 	}
 
+	registryDelete(m.(*AWSClient).AppName, d.Id())
+
 	return nil
 }
 
+// registryPutObjectStore records the object store's key components, so that
+// functions can compile datastore trigger key paths against them
+func registryPutObjectStore(appName string, id string, keyComponents []keyComponent) error {
+	encoded, err := encodeKeyComponents(keyComponents)
+	if err != nil {
+		return err
+	}
+	return registryPutItem(appName, &RegistryItem{
+		Id:   id,
+		Type: "object_store",
+		Attributes: map[string]string{
+			"key_components": encoded,
+		},
+	})
+}
+
 func validateS3BucketName(value string, region string) error {
 	if region != "us-east-1" {
 		if (len(value) < 3) || (len(value) > 63) {
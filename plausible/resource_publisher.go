@@ -7,12 +7,17 @@ import (
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
+// A publisher is an SNS topic that functions publish to through their outputs
+// and subscribe to through subscription triggers. Its tracing decides whether
+// the trace context of published messages reaches subscribed functions.
+
 func resourcePublisher() *schema.Resource {
 	return &schema.Resource{
 		CreateContext: resourcePublisherCreate,
@@ -20,10 +25,29 @@ func resourcePublisher() *schema.Resource {
 		UpdateContext: resourcePublisherUpdate,
 		DeleteContext: resourcePublisherDelete,
 		Schema: map[string]*schema.Schema{
-
-			"uri": {
+			"name": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Computed:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"name_prefix"},
+			},
+			"name_prefix": {
 				Type:     schema.TypeString,
 				Optional: true,
+				ForceNew: true,
+			},
+			"display_name": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"tracing": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateTracingMode,
+			},
+			"uri": {
+				Type:     schema.TypeString,
 				Computed: true,
 			},
 		},
@@ -52,18 +76,14 @@ func resourcePublisherCreate(ctx context.Context, d *schema.ResourceData, m inte
 	}
 	d.SetId(*output.TopicArn)
 
-	if d.HasChange("arn") {
-		_, v := d.GetChange("arn")
-		if err := updateAwsSnsTopicAttribute(d.Id(), "TopicArn", v, conn); err != nil {
-			return diag.Errorf("Error updating ARN for SNS topic: %s", err)
-		}
-	}
-	if d.HasChange("display_name") {
-		_, v := d.GetChange("display_name")
+	if v, ok := d.GetOk("display_name"); ok {
 		if err := updateAwsSnsTopicAttribute(d.Id(), "DisplayName", v, conn); err != nil {
 			return diag.Errorf("Error updating DisplayName for SNS topic: %s", err)
 		}
 	}
+	if err := putPublisherTracing(d, m); err != nil {
+		return diag.FromErr(err)
+	}
 
 	return resourcePublisherRead(ctx, d, m)
 }
@@ -74,35 +94,58 @@ func resourcePublisherRead(ctx context.Context, d *schema.ResourceData, m interf
 	attributeOutput, err := conn.GetTopicAttributes(&sns.GetTopicAttributesInput{
 		TopicArn: aws.String(d.Id()),
 	})
+	if isAWSErr(err, sns.ErrCodeNotFoundException, "") {
+		d.SetId("")
+		return nil
+	}
 	if err != nil {
-		return diag.Errorf(err.Error())
+		return diag.Errorf("Error reading SNS topic %s: %s", d.Id(), err)
 	}
-	if attributeOutput.Attributes != nil && len(attributeOutput.Attributes) > 0 {
-		d.Set("arn", aws.StringValue(attributeOutput.Attributes["TopicArn"]))
-		d.Set("display_name", aws.StringValue(attributeOutput.Attributes["DisplayName"]))
+	d.Set("uri", d.Id())
+	d.Set("display_name", aws.StringValue(attributeOutput.Attributes["DisplayName"]))
+
+	// Tracing is only read back when the topic differs from what is wanted,
+	// so that publishers using the provider's default do not show a change
+	mode := lambda.TracingModePassThrough
+	if aws.StringValue(attributeOutput.Attributes["TracingConfig"]) == lambda.TracingModeActive {
+		mode = lambda.TracingModeActive
+	}
+	if mode != tracingMode(d, m) {
+		d.Set("tracing", mode)
 	}
 
-	if _, ok := d.GetOk("name"); !ok {
-		arn := d.Get("arn").(string)
-		idx := strings.LastIndex(arn, ":")
-		if idx > -1 {
-			d.Set("name", arn[idx+1:])
-		}
+	if idx := strings.LastIndex(d.Id(), ":"); idx > -1 {
+		d.Set("name", d.Id()[idx+1:])
 	}
 
 	return nil
 }
 
 func resourcePublisherUpdate(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
+	conn := m.(*AWSClient).snsconn
+
+	if d.HasChange("display_name") {
+		if err := updateAwsSnsTopicAttribute(d.Id(), "DisplayName", d.Get("display_name"), conn); err != nil {
+			return diag.Errorf("Error updating DisplayName for SNS topic: %s", err)
+		}
+	}
+	if d.HasChange("tracing") {
+		if err := putPublisherTracing(d, m); err != nil {
+			return diag.FromErr(err)
+		}
+	}
 
-	return diags
+	return resourcePublisherRead(ctx, d, m)
 }
 
 func resourcePublisherDelete(ctx context.Context, d *schema.ResourceData, m interface{}) diag.Diagnostics {
-	var diags diag.Diagnostics
-
-	return diags
+	_, err := m.(*AWSClient).snsconn.DeleteTopic(&sns.DeleteTopicInput{
+		TopicArn: aws.String(d.Id()),
+	})
+	if err != nil && !isAWSErr(err, sns.ErrCodeNotFoundException, "") {
+		return diag.Errorf("Error deleting SNS topic %s: %s", d.Id(), err)
+	}
+	return nil
 }
 
 func updateAwsSnsTopicAttribute(topicArn, name string, value interface{}, conn *sns.SNS) error {
@@ -0,0 +1,142 @@
+package plausible
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// The runtimes table lists the runtimes a function may use, when Lambda stops
+// supporting each of them and whether it runs on arm64. A runtime is only
+// given a replacement when moving to it needs no change to the function's
+// code or packaging beyond the runtime setting itself.
+
+type runtimeInfo struct {
+	// Deprecation is the date Lambda stops supporting the runtime, if it has
+	// announced one
+	Deprecation string
+	Replacement string
+	Arm64       bool
+}
+
+var runtimes = map[string]runtimeInfo{
+	"nodejs16.x":      {Deprecation: "2024-06-12", Arm64: true},
+	"nodejs18.x":      {Deprecation: "2025-09-01", Arm64: true},
+	"nodejs20.x":      {Deprecation: "2026-04-30", Arm64: true},
+	"nodejs22.x":      {Deprecation: "2027-04-30", Arm64: true},
+	"nodejs24.x":      {Arm64: true},
+	"python3.7":       {Deprecation: "2023-12-04"},
+	"python3.8":       {Deprecation: "2024-10-14", Arm64: true},
+	"python3.9":       {Deprecation: "2025-12-15", Arm64: true},
+	"python3.10":      {Deprecation: "2026-06-30", Arm64: true},
+	"python3.11":      {Deprecation: "2026-06-30", Arm64: true},
+	"python3.12":      {Deprecation: "2028-10-31", Arm64: true},
+	"python3.13":      {Deprecation: "2029-06-30", Arm64: true},
+	"python3.14":      {Arm64: true},
+	"java8":           {Deprecation: "2024-01-08", Replacement: "java8.al2"},
+	"java8.al2":       {Deprecation: "2026-06-30", Arm64: true},
+	"java11":          {Deprecation: "2026-06-30", Arm64: true},
+	"java17":          {Deprecation: "2026-06-30", Arm64: true},
+	"java21":          {Deprecation: "2029-06-30", Arm64: true},
+	"java25":          {Arm64: true},
+	"dotnet6":         {Deprecation: "2024-12-20", Arm64: true},
+	"dotnet8":         {Deprecation: "2026-11-10", Arm64: true},
+	"ruby3.2":         {Deprecation: "2026-03-31", Arm64: true},
+	"ruby3.3":         {Deprecation: "2027-03-31", Arm64: true},
+	"ruby3.4":         {Arm64: true},
+	"go1.x":           {Deprecation: "2024-01-08"},
+	"provided":        {Deprecation: "2024-01-08", Replacement: "provided.al2023"},
+	"provided.al2":    {Deprecation: "2026-06-30", Arm64: true, Replacement: "provided.al2023"},
+	"provided.al2023": {Arm64: true},
+}
+
+// defaultRuntime is the runtime of functions that do not set one. It is kept
+// although Lambda no longer supports it, since changing it would move every
+// function relying on it to another runtime.
+const defaultRuntime = "python3.7"
+
+// runtimeDeprecationNotice is how long before a runtime's deprecation date
+// plans start warning about it
+const runtimeDeprecationNotice = 180 * 24 * time.Hour
+
+var architectures = []string{lambda.ArchitectureX8664, lambda.ArchitectureArm64}
+
+var validateArchitecture = validation.StringInSlice(architectures, false)
+
+func runtimeNames() []string {
+	names := make([]string, 0, len(runtimes))
+	for name := range runtimes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateRuntime warns about runtimes that are deprecated or will be soon,
+// and about those missing from the table. Lambda adds runtimes more often than
+// the table is updated, so an unknown name is left for Lambda to reject.
+func validateRuntime(v interface{}, path cty.Path) diag.Diagnostics {
+	return runtimeDiagnostics(v.(string), time.Now(), path)
+}
+
+// runtimeDiagnostics warns about the runtime as of now
+func runtimeDiagnostics(name string, now time.Time, path cty.Path) diag.Diagnostics {
+	info, ok := runtimes[name]
+	if !ok {
+		return diag.Diagnostics{{
+			Severity:      diag.Warning,
+			Summary:       fmt.Sprintf("Unknown runtime %q", name),
+			Detail:        fmt.Sprintf("The provider knows the runtimes %v. Lambda rejects the function if it does not support this one.", runtimeNames()),
+			AttributePath: path,
+		}}
+	}
+	if info.Deprecation == "" {
+		return nil
+	}
+
+	deprecation, err := time.Parse("2006-01-02", info.Deprecation)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	var summary string
+	switch {
+	case now.After(deprecation):
+		summary = fmt.Sprintf("Runtime %s reached end of support on %s", name, info.Deprecation)
+	case now.Add(runtimeDeprecationNotice).After(deprecation):
+		summary = fmt.Sprintf("Runtime %s reaches end of support on %s", name, info.Deprecation)
+	default:
+		return nil
+	}
+	detail := "Lambda no longer applies security patches to deprecated runtimes, and eventually blocks creating and updating functions that use them."
+	if info.Replacement != "" {
+		detail += fmt.Sprintf(" Functions can move to %s without other changes.", info.Replacement)
+	}
+	return diag.Diagnostics{{
+		Severity:      diag.Warning,
+		Summary:       summary,
+		Detail:        detail,
+		AttributePath: path,
+	}}
+}
+
+// validateFunctionArchitecture checks that the runtime is available on the
+// chosen architecture. Runtimes missing from the table are left to Lambda.
+func validateFunctionArchitecture(diff *schema.ResourceDiff) error {
+	name := diff.Get("runtime").(string)
+	info, ok := runtimes[name]
+	if !ok {
+		return nil
+	}
+	for _, a := range diff.Get("architectures").([]interface{}) {
+		if a.(string) == lambda.ArchitectureArm64 && !info.Arm64 {
+			return fmt.Errorf("architectures: runtime %s is not available on %s", name, lambda.ArchitectureArm64)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package plausible
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+func TestRuntimeDiagnostics(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name    string
+		runtime string
+		summary string
+	}{
+		{"supported", "python3.13", ""},
+		{"no deprecation announced", "provided.al2023", ""},
+		{"deprecated", "python3.7", "reached end of support on 2023-12-04"},
+		{"deprecated with replacement", "java8", "reached end of support on 2024-01-08"},
+		{"within notice", "nodejs20.x", "reaches end of support on 2026-04-30"},
+		{"outside notice", "nodejs22.x", ""},
+		{"unknown", "cobol85", "Unknown runtime"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			diags := runtimeDiagnostics(c.runtime, now, cty.GetAttrPath("runtime"))
+			if c.summary == "" {
+				if len(diags) != 0 {
+					t.Fatalf("expected no diagnostics, got %v", diags)
+				}
+				return
+			}
+			if len(diags) != 1 {
+				t.Fatalf("expected one diagnostic, got %v", diags)
+			}
+			if diags[0].Severity != diag.Warning {
+				t.Errorf("expected a warning, got severity %v", diags[0].Severity)
+			}
+			if !strings.Contains(diags[0].Summary, c.summary) {
+				t.Errorf("summary %q does not contain %q", diags[0].Summary, c.summary)
+			}
+		})
+	}
+}
+
+func TestRuntimeDiagnosticsReplacement(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	diags := runtimeDiagnostics("java8", now, cty.GetAttrPath("runtime"))
+	if len(diags) != 1 || !strings.Contains(diags[0].Detail, "java8.al2") {
+		t.Fatalf("expected the replacement in the detail, got %v", diags)
+	}
+}
+
+func TestRuntimeDeprecationDates(t *testing.T) {
+	for name, info := range runtimes {
+		if info.Deprecation != "" {
+			if _, err := time.Parse("2006-01-02", info.Deprecation); err != nil {
+				t.Errorf("runtime %s: %s", name, err)
+			}
+		}
+		if info.Replacement != "" {
+			if _, ok := runtimes[info.Replacement]; !ok {
+				t.Errorf("runtime %s: replacement %s is not in the table", name, info.Replacement)
+			}
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package plausible
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule expressions follow the CloudWatch Events syntax, either
+// rate(value unit) or cron(minutes hours day-of-month month day-of-week year).
+// EventBridge Scheduler additionally accepts one-time at(yyyy-mm-ddThh:mm:ss).
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/events/ScheduledEvents.html
+// https://docs.aws.amazon.com/scheduler/latest/UserGuide/schedule-types.html
+
+var (
+	rateExpressionRegexp = regexp.MustCompile(`^rate\(\s*(\d+)\s+([a-z]+)\s*\)$`)
+	atExpressionRegexp   = regexp.MustCompile(`^at\((.*)\)$`)
+	cronExpressionRegexp = regexp.MustCompile(`^cron\((.*)\)$`)
+
+	cronMonthNames = map[string]int{
+		"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+		"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+	}
+	cronWeekdayNames = map[string]int{
+		"SUN": 1, "MON": 2, "TUE": 3, "WED": 4, "THU": 5, "FRI": 6, "SAT": 7,
+	}
+)
+
+const (
+	cronMinYear = 1970
+	cronMaxYear = 2199
+
+	atExpressionLayout = "2006-01-02T15:04:05"
+)
+
+// cronSchedule is a parsed cron() expression. Day-of-month and day-of-week
+// are kept as matchers because L, W and # depend on the month being checked.
+type cronSchedule struct {
+	minutes    []int
+	hours      []int
+	months     map[int]bool
+	years      map[int]bool
+	dayMatches []func(t time.Time) bool
+}
+
+func validateScheduleExpression(val interface{}, key string) (warns []string, errs []error) {
+	if err := checkScheduleExpression(val.(string)); err != nil {
+		errs = append(errs, fmt.Errorf("%q: %s", key, err))
+	}
+	return
+}
+
+func checkScheduleExpression(expr string) error {
+	switch {
+	case strings.HasPrefix(expr, "rate("):
+		_, err := parseRateExpression(expr)
+		return err
+	case strings.HasPrefix(expr, "cron("):
+		_, err := parseCronExpression(expr)
+		return err
+	case strings.HasPrefix(expr, "at("):
+		_, err := parseAtExpression(expr, time.UTC)
+		return err
+	}
+	return fmt.Errorf("schedule expression %q must be of the form rate(value unit), cron(fields) or at(yyyy-mm-ddThh:mm:ss)", expr)
+}
+
+func parseAtExpression(expr string, loc *time.Location) (time.Time, error) {
+	match := atExpressionRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("at expression %q must be of the form at(yyyy-mm-ddThh:mm:ss)", expr)
+	}
+	t, err := time.ParseInLocation(atExpressionLayout, match[1], loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("at expression %q must be of the form at(yyyy-mm-ddThh:mm:ss): %s", expr, err)
+	}
+	return t, nil
+}
+
+func validateScheduleTimezone(val interface{}, key string) (warns []string, errs []error) {
+	if _, err := time.LoadLocation(val.(string)); err != nil {
+		errs = append(errs, fmt.Errorf("%q: %q is not a valid IANA time zone", key, val.(string)))
+	}
+	return
+}
+
+func parseRateExpression(expr string) (time.Duration, error) {
+	match := rateExpressionRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return 0, fmt.Errorf("rate expression %q must be of the form rate(value unit), e.g. rate(5 minutes)", expr)
+	}
+	value, err := strconv.Atoi(match[1])
+	if err != nil || value < 1 {
+		return 0, fmt.Errorf("rate expression %q must have a positive whole number value", expr)
+	}
+
+	var unit time.Duration
+	singular := strings.TrimSuffix(match[2], "s")
+	switch singular {
+	case "minute":
+		unit = time.Minute
+	case "hour":
+		unit = time.Hour
+	case "day":
+		unit = 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("rate expression %q has unit %q, which must be minute(s), hour(s) or day(s)", expr, match[2])
+	}
+	if value == 1 && match[2] != singular {
+		return 0, fmt.Errorf("rate expression %q must use the singular unit %q for a value of 1", expr, singular)
+	}
+	if value > 1 && match[2] == singular {
+		return 0, fmt.Errorf("rate expression %q must use the plural unit %q for a value greater than 1", expr, singular+"s")
+	}
+
+	return time.Duration(value) * unit, nil
+}
+
+func parseCronExpression(expr string) (*cronSchedule, error) {
+	match := cronExpressionRegexp.FindStringSubmatch(expr)
+	if match == nil {
+		return nil, fmt.Errorf("cron expression %q must be of the form cron(fields)", expr)
+	}
+	fields := strings.Fields(match[1])
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("cron expression %q must have 6 fields (minutes hours day-of-month month day-of-week year), found %d", expr, len(fields))
+	}
+
+	s := &cronSchedule{}
+	minutes, err := parseCronField(fields[0], 0, 59, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cron expression %q has invalid minutes: %s", expr, err)
+	}
+	hours, err := parseCronField(fields[1], 0, 23, nil)
+	if err != nil {
+		return nil, fmt.Errorf("cron expression %q has invalid hours: %s", expr, err)
+	}
+	if s.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, fmt.Errorf("cron expression %q has invalid month: %s", expr, err)
+	}
+	if s.years, err = parseCronField(fields[5], cronMinYear, cronMaxYear, nil); err != nil {
+		return nil, fmt.Errorf("cron expression %q has invalid year: %s", expr, err)
+	}
+	s.minutes = sortedCronValues(minutes)
+	s.hours = sortedCronValues(hours)
+
+	dom, dow := fields[2], fields[4]
+	if (dom == "?") == (dow == "?") {
+		return nil, fmt.Errorf("cron expression %q must use '?' in exactly one of day-of-month or day-of-week", expr)
+	}
+	if dom != "?" {
+		if s.dayMatches, err = parseCronDayOfMonth(dom); err != nil {
+			return nil, fmt.Errorf("cron expression %q has invalid day-of-month: %s", expr, err)
+		}
+	} else {
+		if s.dayMatches, err = parseCronDayOfWeek(dow); err != nil {
+			return nil, fmt.Errorf("cron expression %q has invalid day-of-week: %s", expr, err)
+		}
+	}
+
+	return s, nil
+}
+
+// parseCronField expands a comma separated list of values, ranges (a-b),
+// wildcards and increments (a/n, a-b/n, */n) into the set of matching values.
+func parseCronField(field string, min, max int, names map[string]int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i > -1 {
+			var err error
+			rangePart = part[:i]
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("%q has an invalid increment", part)
+			}
+		}
+
+		start, end := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return nil, err
+			}
+			if end, err = parseCronValue(bounds[1], min, max, names); err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("%q is an empty range", part)
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(rangePart, min, max, names); err != nil {
+				return nil, err
+			}
+			if !strings.Contains(part, "/") {
+				end = start
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+func parseCronValue(s string, min, max int, names map[string]int) (int, error) {
+	if v, ok := names[strings.ToUpper(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a valid value", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d is outside the range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+var cronNearestWeekdayRegexp = regexp.MustCompile(`^(\d+)W$`)
+
+func parseCronDayOfMonth(field string) ([]func(t time.Time) bool, error) {
+	matches := []func(t time.Time) bool{}
+	for _, part := range strings.Split(field, ",") {
+		switch {
+		case part == "L":
+			matches = append(matches, func(t time.Time) bool {
+				return t.Day() == daysInMonth(t)
+			})
+		case part == "LW":
+			matches = append(matches, func(t time.Time) bool {
+				return t.Day() == nearestWeekday(t, daysInMonth(t))
+			})
+		case cronNearestWeekdayRegexp.MatchString(part):
+			day, err := parseCronValue(cronNearestWeekdayRegexp.FindStringSubmatch(part)[1], 1, 31, nil)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, func(t time.Time) bool {
+				return day <= daysInMonth(t) && t.Day() == nearestWeekday(t, day)
+			})
+		default:
+			days, err := parseCronField(part, 1, 31, nil)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, func(t time.Time) bool {
+				return days[t.Day()]
+			})
+		}
+	}
+	return matches, nil
+}
+
+var (
+	cronLastWeekdayRegexp = regexp.MustCompile(`^(\w+)L$`)
+	cronNthWeekdayRegexp  = regexp.MustCompile(`^(\w+)#(\d)$`)
+)
+
+func parseCronDayOfWeek(field string) ([]func(t time.Time) bool, error) {
+	matches := []func(t time.Time) bool{}
+	for _, part := range strings.Split(field, ",") {
+		switch {
+		case part == "L":
+			matches = append(matches, func(t time.Time) bool {
+				return t.Weekday() == time.Saturday
+			})
+		case cronLastWeekdayRegexp.MatchString(part):
+			weekday, err := parseCronValue(cronLastWeekdayRegexp.FindStringSubmatch(part)[1], 1, 7, cronWeekdayNames)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, func(t time.Time) bool {
+				return cronWeekday(t) == weekday && t.Day()+7 > daysInMonth(t)
+			})
+		case cronNthWeekdayRegexp.MatchString(part):
+			submatch := cronNthWeekdayRegexp.FindStringSubmatch(part)
+			weekday, err := parseCronValue(submatch[1], 1, 7, cronWeekdayNames)
+			if err != nil {
+				return nil, err
+			}
+			nth, err := parseCronValue(submatch[2], 1, 5, nil)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, func(t time.Time) bool {
+				return cronWeekday(t) == weekday && (t.Day()-1)/7+1 == nth
+			})
+		default:
+			weekdays, err := parseCronField(part, 1, 7, cronWeekdayNames)
+			if err != nil {
+				return nil, err
+			}
+			matches = append(matches, func(t time.Time) bool {
+				return weekdays[cronWeekday(t)]
+			})
+		}
+	}
+	return matches, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	if !s.years[t.Year()] || !s.months[int(t.Month())] {
+		return false
+	}
+	for _, match := range s.dayMatches {
+		if match(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextScheduleInvocations returns up to count times after from at which the
+// schedule expression fires, evaluated in the given time zone. Rate schedules
+// are measured from the time the schedule is created, so for those the
+// preview is relative to from.
+func nextScheduleInvocations(expr string, from time.Time, count int, loc *time.Location) ([]time.Time, error) {
+	from = from.In(loc).Truncate(time.Minute)
+	times := []time.Time{}
+
+	switch {
+	case strings.HasPrefix(expr, "rate("):
+		interval, err := parseRateExpression(expr)
+		if err != nil {
+			return nil, err
+		}
+		for i := 1; i <= count; i++ {
+			times = append(times, from.Add(time.Duration(i)*interval))
+		}
+		return times, nil
+	case strings.HasPrefix(expr, "at("):
+		t, err := parseAtExpression(expr, loc)
+		if err != nil {
+			return nil, err
+		}
+		if t.After(from) && count > 0 {
+			times = append(times, t)
+		}
+		return times, nil
+	}
+
+	s, err := parseCronExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	for day.Year() <= cronMaxYear && len(times) < count {
+		if s.matchesDay(day) {
+			for _, hour := range s.hours {
+				for _, minute := range s.minutes {
+					t := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, loc)
+					// Times skipped when daylight saving starts do not exist,
+					// and the schedule does not fire for them
+					if t.Hour() != hour || t.Minute() != minute {
+						continue
+					}
+					if t.After(from) && len(times) < count {
+						times = append(times, t)
+					}
+				}
+			}
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day()+1, 0, 0, 0, 0, loc)
+	}
+	return times, nil
+}
+
+func sortedCronValues(values map[int]bool) []int {
+	sorted := make([]int, 0, len(values))
+	for v := range values {
+		sorted = append(sorted, v)
+	}
+	sort.Ints(sorted)
+	return sorted
+}
+
+// cronWeekday converts a time.Weekday to the cron numbering, where SUN is 1
+func cronWeekday(t time.Time) int {
+	return int(t.Weekday()) + 1
+}
+
+func daysInMonth(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// nearestWeekday returns the day of t's month that is the weekday closest to
+// the given day, without crossing into the previous or next month
+func nearestWeekday(t time.Time, day int) int {
+	last := daysInMonth(t)
+	d := time.Date(t.Year(), t.Month(), day, 0, 0, 0, 0, time.UTC)
+	switch d.Weekday() {
+	case time.Saturday:
+		if day == 1 {
+			return day + 2
+		}
+		return day - 1
+	case time.Sunday:
+		if day == last {
+			return day - 2
+		}
+		return day + 1
+	}
+	return day
+}
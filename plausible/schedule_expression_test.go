@@ -0,0 +1,303 @@
+package plausible
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckScheduleExpression(t *testing.T) {
+	cases := []struct {
+		expr  string
+		valid bool
+	}{
+		{"rate(1 minute)", true},
+		{"rate(5 minutes)", true},
+		{"rate(1 hour)", true},
+		{"rate(2 days)", true},
+		{"rate(1 minutes)", false},
+		{"rate(5 minute)", false},
+		{"rate(0 minutes)", false},
+		{"rate(5 seconds)", false},
+		{"rate(5)", false},
+		{"cron(0 12 * * ? *)", true},
+		{"cron(15 10 ? * 6L 2022-2023)", true},
+		{"cron(0/5 8-17 ? * MON-FRI *)", true},
+		{"cron(0 0 L * ? *)", true},
+		{"cron(0 0 LW * ? *)", true},
+		{"cron(0 0 15W * ? *)", true},
+		{"cron(0 0 ? * 3#2 *)", true},
+		{"cron(0 0 1,15 JAN,JUL ? *)", true},
+		{"cron(0 12 * * * *)", false},
+		{"cron(0 12 ? * ? *)", false},
+		{"cron(0 12 * * ?)", false},
+		{"cron(60 12 * * ? *)", false},
+		{"cron(0 24 * * ? *)", false},
+		{"cron(0 0 32 * ? *)", false},
+		{"cron(0 0 ? * 8 *)", false},
+		{"cron(0 0 5-1 * ? *)", false},
+		{"cron(0/0 0 * * ? *)", false},
+		{"cron(0 0 * FOO ? *)", false},
+		{"cron(0 0 * * ? 1969)", false},
+		{"at(2026-01-01T00:00:00)", true},
+		{"at(2026-01-01)", false},
+		{"at(2026-13-01T00:00:00)", false},
+		{"every 5 minutes", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		err := checkScheduleExpression(c.expr)
+		if c.valid && err != nil {
+			t.Errorf("%q: unexpected error: %s", c.expr, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("%q: expected an error", c.expr)
+		}
+	}
+}
+
+func TestParseRateExpression(t *testing.T) {
+	cases := map[string]time.Duration{
+		"rate(1 minute)":   time.Minute,
+		"rate(15 minutes)": 15 * time.Minute,
+		"rate(1 hour)":     time.Hour,
+		"rate(3 hours)":    3 * time.Hour,
+		"rate(1 day)":      24 * time.Hour,
+		"rate(7 days)":     7 * 24 * time.Hour,
+	}
+	for expr, want := range cases {
+		got, err := parseRateExpression(expr)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", expr, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: got %s, want %s", expr, got, want)
+		}
+	}
+}
+
+func TestValidateScheduleTimezone(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Paris"); err != nil {
+		t.Skipf("time zone database unavailable: %s", err)
+	}
+	if _, errs := validateScheduleTimezone("Europe/Paris", "timezone"); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if _, errs := validateScheduleTimezone("Europe/Atlantis", "timezone"); len(errs) == 0 {
+		t.Error("expected an error for an unknown time zone")
+	}
+}
+
+func utc(year int, month time.Month, day, hour, min int) time.Time {
+	return time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+}
+
+func TestNextScheduleInvocations(t *testing.T) {
+	// A Thursday
+	from := time.Date(2026, time.January, 1, 10, 7, 30, 0, time.UTC)
+	cases := []struct {
+		name  string
+		expr  string
+		count int
+		want  []time.Time
+	}{
+		{
+			name:  "rate from the minute",
+			expr:  "rate(5 minutes)",
+			count: 3,
+			want:  []time.Time{utc(2026, 1, 1, 10, 12), utc(2026, 1, 1, 10, 17), utc(2026, 1, 1, 10, 22)},
+		},
+		{
+			name:  "rate in days",
+			expr:  "rate(1 day)",
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 2, 10, 7), utc(2026, 1, 3, 10, 7)},
+		},
+		{
+			name:  "at in the future",
+			expr:  "at(2026-02-01T09:00:00)",
+			count: 3,
+			want:  []time.Time{utc(2026, 2, 1, 9, 0)},
+		},
+		{
+			name:  "at in the past",
+			expr:  "at(2025-12-31T09:00:00)",
+			count: 3,
+			want:  []time.Time{},
+		},
+		{
+			name:  "daily",
+			expr:  "cron(0 12 * * ? *)",
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 1, 12, 0), utc(2026, 1, 2, 12, 0)},
+		},
+		{
+			name:  "increments later the same hour",
+			expr:  "cron(0/15 10 * * ? *)",
+			count: 3,
+			want:  []time.Time{utc(2026, 1, 1, 10, 15), utc(2026, 1, 1, 10, 30), utc(2026, 1, 1, 10, 45)},
+		},
+		{
+			name:  "weekdays",
+			expr:  "cron(0 9 ? * MON-FRI *)",
+			count: 3,
+			want:  []time.Time{utc(2026, 1, 2, 9, 0), utc(2026, 1, 5, 9, 0), utc(2026, 1, 6, 9, 0)},
+		},
+		{
+			name:  "last day of month",
+			expr:  "cron(0 0 L * ? *)",
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 31, 0, 0), utc(2026, 2, 28, 0, 0)},
+		},
+		{
+			name:  "last weekday of month",
+			expr:  "cron(0 0 LW * ? *)",
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 30, 0, 0), utc(2026, 2, 27, 0, 0)},
+		},
+		{
+			name:  "nearest weekday to a Sunday",
+			expr:  "cron(0 0 1W * ? *)",
+			count: 2,
+			want:  []time.Time{utc(2026, 2, 2, 0, 0), utc(2026, 3, 2, 0, 0)},
+		},
+		{
+			name:  "third Friday",
+			expr:  "cron(0 0 ? * 6#3 *)",
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 16, 0, 0), utc(2026, 2, 20, 0, 0)},
+		},
+		{
+			name:  "last Monday",
+			expr:  "cron(0 0 ? * 2L *)",
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 26, 0, 0), utc(2026, 2, 23, 0, 0)},
+		},
+		{
+			name:  "year",
+			expr:  "cron(0 0 1 1 ? 2027)",
+			count: 3,
+			want:  []time.Time{utc(2027, 1, 1, 0, 0)},
+		},
+		{
+			name:  "years that have passed",
+			expr:  "cron(0 0 1 1 ? 2020-2025)",
+			count: 3,
+			want:  []time.Time{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := nextScheduleInvocations(c.expr, from, c.count, time.UTC)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			assertInvocations(t, got, c.want)
+		})
+	}
+}
+
+func TestNextScheduleInvocationsTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %s", err)
+	}
+	cases := []struct {
+		name  string
+		expr  string
+		from  time.Time
+		count int
+		want  []time.Time
+	}{
+		{
+			name:  "local time",
+			expr:  "cron(0 9 * * ? *)",
+			from:  utc(2026, 1, 1, 10, 7),
+			count: 2,
+			want:  []time.Time{utc(2026, 1, 1, 14, 0), utc(2026, 1, 2, 14, 0)},
+		},
+		{
+			name:  "local day boundary",
+			expr:  "cron(0 21 * * ? *)",
+			from:  utc(2026, 1, 2, 1, 0),
+			count: 1,
+			// 20:00 on January 1 in New York, so 21:00 is still to come
+			want: []time.Time{utc(2026, 1, 2, 2, 0)},
+		},
+		{
+			name:  "daylight saving starts",
+			expr:  "cron(0 12 * * ? *)",
+			from:  utc(2026, 3, 7, 18, 0),
+			count: 2,
+			want:  []time.Time{utc(2026, 3, 8, 16, 0), utc(2026, 3, 9, 16, 0)},
+		},
+		{
+			// 02:30 does not exist on March 8, so there is no invocation that day
+			name:  "skipped hour",
+			expr:  "cron(30 2 * * ? *)",
+			from:  utc(2026, 3, 7, 12, 0),
+			count: 3,
+			want:  []time.Time{utc(2026, 3, 9, 6, 30), utc(2026, 3, 10, 6, 30), utc(2026, 3, 11, 6, 30)},
+		},
+		{
+			name:  "daylight saving ends",
+			expr:  "cron(0 12 * * ? *)",
+			from:  utc(2026, 10, 31, 18, 0),
+			count: 2,
+			want:  []time.Time{utc(2026, 11, 1, 17, 0), utc(2026, 11, 2, 17, 0)},
+		},
+		{
+			name:  "rate across daylight saving",
+			expr:  "rate(1 day)",
+			from:  utc(2026, 3, 7, 12, 0),
+			count: 2,
+			want:  []time.Time{utc(2026, 3, 8, 12, 0), utc(2026, 3, 9, 12, 0)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := nextScheduleInvocations(c.expr, c.from, c.count, loc)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			assertInvocations(t, got, c.want)
+			for _, g := range got {
+				if g.Location() != loc {
+					t.Errorf("%s is not in %s", g, loc)
+				}
+			}
+		})
+	}
+}
+
+func TestNextScheduleInvocationsRepeatedHour(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %s", err)
+	}
+	// 01:30 happens twice on November 1, but the schedule fires once a day
+	got, err := nextScheduleInvocations("cron(30 1 * * ? *)", utc(2026, 10, 31, 12, 0), 3, loc)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("got %d invocations, want 3", len(got))
+	}
+	for i, g := range got {
+		if g.Hour() != 1 || g.Minute() != 30 || g.Day() != 1+i {
+			t.Errorf("invocation %d at %s", i, g)
+		}
+	}
+}
+
+func assertInvocations(t *testing.T, got, want []time.Time) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("invocation %d: got %s, want %s", i, got[i].UTC(), want[i])
+		}
+	}
+}
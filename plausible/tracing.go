@@ -0,0 +1,65 @@
+package plausible
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// Tracing is Active, to sample and record requests with X-Ray, or
+// PassThrough, to only pass on the trace context of requests that are already
+// traced. Resources that do not set tracing use the provider's default.
+
+var tracingModes = []string{lambda.TracingModeActive, lambda.TracingModePassThrough}
+
+var validateTracingMode = validation.StringInSlice(tracingModes, false)
+
+// tracingMode returns the tracing mode of a resource, falling back to the
+// provider's default
+func tracingMode(d *schema.ResourceData, m interface{}) string {
+	if v, ok := d.GetOk("tracing"); ok {
+		return v.(string)
+	}
+	return m.(*AWSClient).Tracing
+}
+
+func tracingPolicyName(functionName string) string {
+	return fmt.Sprintf("%s-tracing", functionName)
+}
+
+func expandTracingConfig(d *schema.ResourceData, m interface{}) *lambda.TracingConfig {
+	return &lambda.TracingConfig{
+		Mode: aws.String(tracingMode(d, m)),
+	}
+}
+
+// putFunctionTracingPolicy lets an actively traced function send its segments
+// to X-Ray, and removes that grant otherwise
+func putFunctionTracingPolicy(d *schema.ResourceData, m interface{}) error {
+	statements := []*iamPolicyStatement{}
+	if tracingMode(d, m) == lambda.TracingModeActive {
+		statements = append(statements, &iamPolicyStatement{
+			Effect: "Allow",
+			Action: []string{
+				"xray:PutTraceSegments",
+				"xray:PutTelemetryRecords",
+			},
+			Resource: []string{"*"},
+		})
+	}
+	return putRoleInlinePolicy(m.(*AWSClient).iamconn, d.Get("role").(string), tracingPolicyName(d.Get("function_name").(string)), statements)
+}
+
+// putPublisherTracing sets the tracing of a publisher. When it is Active, SNS
+// adds the trace header to what it delivers to subscription queues, and SQS
+// carries it to the event source mapping, which continues the trace in the
+// subscribed function.
+func putPublisherTracing(d *schema.ResourceData, m interface{}) error {
+	if err := updateAwsSnsTopicAttribute(d.Id(), "TracingConfig", tracingMode(d, m), m.(*AWSClient).snsconn); err != nil {
+		return fmt.Errorf("Error updating tracing of publisher %s: %s", d.Id(), err)
+	}
+	return nil
+}